@@ -13,6 +13,7 @@ $ECHO Cleaning up
 rm -rf bin pkg
 
 $ECHO Fetching deps
+go get code.google.com/p/go.crypto/blowfish
 go get code.google.com/p/go.crypto/scrypt
 go get github.com/golang/mock/gomock
 go get github.com/golang/mock/mockgen
@@ -29,7 +30,7 @@ while read pkg itf; do
 done <<EOF
 gate/server Server
 gate/client/commands Commander,Command
-gate/client/remote Remoter,Remote,Proxy
+gate/client/remote Remoter,Remote,HistoryRemote,Proxy
 gate/client/ui UserInteraction
 gate/core Config,XdgContext
 EOF
@@ -38,6 +39,10 @@ $ECHO Launching tests
 go test -i $TESTS
 go test $TESTS || exit 1
 
+# the server is concurrent: look for data races too
+$ECHO Launching race tests
+go test -race gate/server/impl gate/server/channel || exit 1
+
 $ECHO
 case $BUILD in
     go)
@@ -19,3 +19,6 @@ done
 for rc in $ROOTDIR/conf/*.rc; do
     cp $rc $DESTDIR/etc/xdg/gate/
 done
+
+mkdir -p $DESTDIR/usr/lib/systemd/user
+cp $ROOTDIR/conf/gate.service $DESTDIR/usr/lib/systemd/user/
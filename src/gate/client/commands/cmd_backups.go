@@ -0,0 +1,128 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type cmd_backups cmd
+
+var _ Command = &cmd_backups{}
+
+func (self *cmd_backups) Name() string {
+	return "backups"
+}
+
+func (self *cmd_backups) Run(line []string) (err error) {
+	var backups []server.Backup
+	err = self.server.Backups("", &backups)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case len(line) == 1:
+		err = self.mmi.Pager(backupsReport(backups))
+	case len(line) == 3 && line[1] == "restore":
+		err = self.restore(backups, line[2])
+	default:
+		err = errors.New("Invalid arguments")
+	}
+	return
+}
+
+func backupsReport(backups []server.Backup) string {
+	if len(backups) == 0 {
+		return "No backups\n"
+	}
+	text := []string{}
+	for i, backup := range backups {
+		text = append(text, fmt.Sprintf("  %2d  %s  %8d bytes  %s", i+1, formatTime(backup.Date), backup.Size, backup.Name))
+	}
+	text = append(text, "")
+	return strings.Join(text, "\n")
+}
+
+// The backup is either its number in the list, or its name
+func (self *cmd_backups) restore(backups []server.Backup, which string) (err error) {
+	var name string
+	n, e := strconv.Atoi(which)
+	if e == nil {
+		if n < 1 || n > len(backups) {
+			return errors.Newf("Invalid backup: %d", n)
+		}
+		name = backups[n-1].Name
+	} else {
+		name = which
+	}
+
+	choice, err := self.mmi.Choose(fmt.Sprintf("Replace the vault by the backup %s?\nThe current vault is backed up first.", name), []string{"no", "yes"})
+	if err != nil || choice != "yes" {
+		return
+	}
+
+	var restored bool
+	err = self.server.RestoreBackup(name, &restored)
+	if err != nil {
+		return
+	}
+	if !restored {
+		err = errors.Newf("Could not restore %s", name)
+	}
+	return
+}
+
+func (self *cmd_backups) Complete(line []string) (result []string, err error) {
+	switch len(line) {
+	case 2:
+		if strings.HasPrefix("restore", line[1]) {
+			result = []string{"restore"}
+		}
+	case 3:
+		if line[1] == "restore" {
+			var backups []server.Backup
+			err = self.server.Backups(fmt.Sprintf("^%s", line[2]), &backups)
+			for _, backup := range backups {
+				result = append(result, backup.Name)
+			}
+		}
+	}
+	return
+}
+
+func (self *cmd_backups) Help(line []string) (result string, err error) {
+
+	result = `
+[33mbackups [restore <n>][0m
+		   List the backups of the vault, most recent first. The
+		   vault file is backed up each time it is saved; the
+		   [33m[server] backups[0m configuration key tells how many are
+		   kept (default 5).
+		   With [33mrestore[0m, replace the vault by the given backup
+		   (its number or its name). The vault is then locked: its
+		   encryption phrase is asked again.
+`
+
+	return
+}
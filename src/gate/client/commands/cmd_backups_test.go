@@ -0,0 +1,124 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/client/remote"
+	"gate/client/ui"
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"testing"
+	"time"
+)
+
+var testBackups = []server.Backup{
+	{Name: "vault-20150102-030405.000000000", Date: time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC), Size: 1234},
+	{Name: "vault-20150101-030405.000000000", Date: time.Date(2015, 1, 1, 3, 4, 5, 0, time.UTC), Size: 1200},
+}
+
+func TestBackupsList(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	backups := &cmd_backups{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().Backups("", gomock.Any()).Do(func(_ string, reply *[]server.Backup) {
+		*reply = testBackups
+	})
+	mmi.EXPECT().Pager(backupsReport(testBackups))
+
+	err := backups.Run([]string{"backups"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackupsRestore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	backups := &cmd_backups{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().Backups("", gomock.Any()).Do(func(_ string, reply *[]server.Backup) {
+		*reply = testBackups
+	})
+	mmi.EXPECT().Choose(gomock.Any(), []string{"no", "yes"}).Return("yes", nil)
+	srv.EXPECT().RestoreBackup("vault-20150101-030405.000000000", gomock.Any()).Do(func(_ string, reply *bool) {
+		*reply = true
+	})
+
+	err := backups.Run([]string{"backups", "restore", "2"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackupsRestoreCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	backups := &cmd_backups{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().Backups("", gomock.Any()).Do(func(_ string, reply *[]server.Backup) {
+		*reply = testBackups
+	})
+	mmi.EXPECT().Choose(gomock.Any(), []string{"no", "yes"}).Return("no", nil)
+
+	err := backups.Run([]string{"backups", "restore", "1"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackupsRestoreInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	backups := &cmd_backups{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().Backups("", gomock.Any()).Do(func(_ string, reply *[]server.Backup) {
+		*reply = testBackups
+	})
+
+	err := backups.Run([]string{"backups", "restore", "3"})
+	if err == nil {
+		t.Error("expected error")
+	}
+}
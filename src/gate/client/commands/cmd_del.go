@@ -15,6 +15,14 @@
 
 package commands
 
+import (
+	"gate/core/errors"
+)
+
+import (
+	"fmt"
+)
+
 type cmd_del cmd
 
 var _ Command = &cmd_del{}
@@ -24,13 +32,36 @@ func (self *cmd_del) Name() string {
 }
 
 func (self *cmd_del) Run(line []string) (err error) {
+	if len(line) != 2 {
+		return errors.New("Invalid arguments")
+	}
+
+	var deleted bool
+	err = self.server.Unset(line[1], &deleted)
+	if err != nil {
+		return
+	}
+	if !deleted {
+		err = errors.Newf("Could not delete %s", line[1])
+	}
 	return
 }
 
 func (self *cmd_del) Complete(line []string) (result []string, err error) {
+	if len(line) == 2 {
+		word := line[1]
+		err = self.server.List(fmt.Sprintf("^%s", word), &result)
+	}
 	return
 }
 
 func (self *cmd_del) Help(line []string) (result string, err error) {
+
+	result = `
+[33mdel <key>[0m	   Delete the password using the given key.
+		   The key is kept as "deleted" in the vault, so that
+		   [33mmerge[0m can propagate the deletion to other vaults.
+`
+
 	return
 }
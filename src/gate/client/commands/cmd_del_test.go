@@ -0,0 +1,91 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/client/remote"
+	"gate/client/ui"
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"reflect"
+	"testing"
+)
+
+func TestDelRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	del := &cmd_del{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().Unset("foo", gomock.Any()).Do(func(_ string, reply *bool) {
+		*reply = true
+	})
+
+	err := del.Run([]string{"del", "foo"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDelRunNoKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	del := &cmd_del{cmd, rem, srv, cfg, mmi}
+
+	err := del.Run([]string{"del"})
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestDelComplete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	del := &cmd_del{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().List("^fo", gomock.Any()).Do(func(_ string, reply *[]string) {
+		*reply = []string{"foo", "foobar"}
+	})
+
+	result, err := del.Complete([]string{"del", "fo"})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(result, []string{"foo", "foobar"}) {
+		t.Errorf("bad completion: %v", result)
+	}
+}
@@ -0,0 +1,79 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/core/errors"
+)
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type cmd_history cmd
+
+var _ Command = &cmd_history{}
+
+func (self *cmd_history) Name() string {
+	return "history"
+}
+
+func (self *cmd_history) Run(line []string) (err error) {
+	if len(line) != 2 {
+		return errors.New("Invalid arguments")
+	}
+
+	var history []time.Time
+	err = self.server.History(line[1], &history)
+	if err != nil {
+		return
+	}
+
+	var text []string
+	if len(history) == 0 {
+		text = []string{fmt.Sprintf("No previous password for %s", line[1]), ""}
+	} else {
+		text = make([]string, 0, len(history)+2)
+		text = append(text, fmt.Sprintf("[1m%s[0m", line[1]))
+		for i, date := range history {
+			text = append(text, fmt.Sprintf("  %2d  ********  replaced %s", i+1, formatTime(date)))
+		}
+		text = append(text, "")
+	}
+
+	err = self.mmi.Pager(strings.Join(text, "\n"))
+	return
+}
+
+func (self *cmd_history) Complete(line []string) (result []string, err error) {
+	if len(line) == 2 {
+		err = self.server.List(fmt.Sprintf("^%s", line[1]), &result)
+	}
+	return
+}
+
+func (self *cmd_history) Help(line []string) (result string, err error) {
+
+	result = `
+[33mhistory <key>[0m	   List the previous passwords of the given key
+		   (the passwords themselves are not shown).
+		   See also [33mrollback[0m.
+`
+
+	return
+}
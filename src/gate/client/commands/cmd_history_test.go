@@ -0,0 +1,75 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/client/remote"
+	"gate/client/ui"
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"testing"
+	"time"
+)
+
+func TestHistoryRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	history := &cmd_history{cmd, rem, srv, cfg, mmi}
+
+	date := time.Date(2015, 3, 14, 15, 9, 26, 0, time.Local)
+	srv.EXPECT().History("foo", gomock.Any()).Do(func(_ string, reply *[]time.Time) {
+		*reply = []time.Time{date, time.Time{}}
+	})
+	mmi.EXPECT().Pager(`[1mfoo[0m
+   1  ********  replaced 2015-03-14 15:09:26
+   2  ********  replaced unknown
+`)
+
+	err := history.Run([]string{"history", "foo"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHistoryRunEmpty(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	history := &cmd_history{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().History("foo", gomock.Any())
+	mmi.EXPECT().Pager("No previous password for foo\n")
+
+	err := history.Run([]string{"history", "foo"})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,119 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type cmd_info cmd
+
+var _ Command = &cmd_info{}
+
+func (self *cmd_info) Name() string {
+	return "info"
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func (self *cmd_info) Run(line []string) (err error) {
+	args := server.InfoArgs{}
+	switch len(line) {
+	case 2:
+		args.Key = line[1]
+	case 3:
+		if line[2] != "password" {
+			return errors.Newf("Unrecognized argument: '%s'", line[2])
+		}
+		args.Key = line[1]
+		args.WithPassword = true
+	default:
+		return errors.New("Invalid arguments")
+	}
+
+	var info server.KeyInfo
+	err = self.server.Info(args, &info)
+	if err != nil {
+		return
+	}
+
+	text := []string{fmt.Sprintf("[1m%s[0m", info.Name)}
+	if args.WithPassword {
+		text = append(text, fmt.Sprintf("  password: %s", info.Password))
+	}
+	for _, field := range []struct{ name, value string }{
+		{"username", info.Username},
+		{"url", info.Url},
+		{"notes", info.Notes},
+	} {
+		if field.value != "" {
+			text = append(text, fmt.Sprintf("  %s: %s", field.name, field.value))
+		}
+	}
+	names := make([]string, 0, len(info.Fields))
+	for name := range info.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		text = append(text, fmt.Sprintf("  %s: %s", name, info.Fields[name]))
+	}
+	text = append(text,
+		fmt.Sprintf("  created: %s", formatTime(info.Created)),
+		fmt.Sprintf("  modified: %s", formatTime(info.Modified)),
+		"",
+	)
+
+	err = self.mmi.Pager(strings.Join(text, "\n"))
+	return
+}
+
+func (self *cmd_info) Complete(line []string) (result []string, err error) {
+	switch len(line) {
+	case 2:
+		err = self.server.List(fmt.Sprintf("^%s", line[1]), &result)
+	case 3:
+		if strings.HasPrefix("password", line[2]) {
+			result = []string{"password"}
+		}
+	}
+	return
+}
+
+func (self *cmd_info) Help(line []string) (result string, err error) {
+
+	result = `
+[33minfo <key> [password][0m
+		   Show the information stored with the given key
+		   (username, url, notes, other fields, and dates).
+		   The password is only shown if [33mpassword[0m is given.
+`
+
+	return
+}
@@ -0,0 +1,90 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/client/remote"
+	"gate/client/ui"
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"testing"
+)
+
+func TestInfoRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	info := &cmd_info{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().Info(server.InfoArgs{Key: "foo"}, gomock.Any()).Do(func(_ server.InfoArgs, reply *server.KeyInfo) {
+		*reply = server.KeyInfo{
+			Name:     "foo",
+			Username: "john",
+			Url:      "https://example.com",
+			Fields:   map[string]string{"pin": "1234"},
+		}
+	})
+	mmi.EXPECT().Pager(`[1mfoo[0m
+  username: john
+  url: https://example.com
+  pin: 1234
+  created: unknown
+  modified: unknown
+`)
+
+	err := info.Run([]string{"info", "foo"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInfoRunPassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	info := &cmd_info{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().Info(server.InfoArgs{Key: "foo", WithPassword: true}, gomock.Any()).Do(func(_ server.InfoArgs, reply *server.KeyInfo) {
+		*reply = server.KeyInfo{
+			Name:     "foo",
+			Password: "secret",
+		}
+	})
+	mmi.EXPECT().Pager(`[1mfoo[0m
+  password: secret
+  created: unknown
+  modified: unknown
+`)
+
+	err := info.Run([]string{"info", "foo", "password"})
+	if err != nil {
+		t.Error(err)
+	}
+}
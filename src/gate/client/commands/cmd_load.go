@@ -15,8 +15,15 @@
 
 package commands
 
+import (
+	"gate/client/ui"
+	"gate/core/errors"
+	"gate/server"
+)
+
 import (
 	"fmt"
+	"os"
 )
 
 type cmd_load cmd
@@ -40,13 +47,62 @@ func (self *cmd_load) Run(line []string) (err error) {
 		return
 	}
 
-	vault_path, err := self.config.VaultPath()
+	xdg, err := self.config.Xdg()
+	if err != nil {
+		return
+	}
+
+	dir, err := xdg.RuntimeDir()
 	if err != nil {
 		return
 	}
 
-	err = remote.LoadVault(vault_path)
+	// never downloaded over the local vault: the server swaps it in,
+	// once checked
+	load_vault := fmt.Sprintf("%s/load_vault", dir)
+	defer os.Remove(load_vault)
 
+	err = remote.LoadVault(load_vault)
+	if err != nil {
+		return
+	}
+
+	loaded, err := loadVault(self.server, self.mmi, load_vault)
+	if err == nil && loaded {
+		remote.AcceptVault()
+	}
+	return
+}
+
+// Ask the encryption phrase of the downloaded vault file, check it, and
+// have the server swap it in place of the local vault
+func loadVault(srv server.Server, mmi ui.UserInteraction, file string) (loaded bool, err error) {
+	pass, err := mmi.ReadPassword(`Please enter the encryption phrase
+to the remote vault`)
+	if err != nil || pass == "" {
+		return
+	}
+
+	var report server.VerifyReport
+	err = srv.Verify(server.VerifyArgs{Vault: file, Master: pass}, &report)
+	if err != nil {
+		return
+	}
+	if len(report.Problems) > 0 {
+		err = mmi.Pager(verifyReport(report))
+		if err != nil {
+			return
+		}
+		return false, errors.New("Invalid remote vault: not loaded")
+	}
+
+	err = srv.Load(server.MergeArgs{Vault: file, Master: pass}, &loaded)
+	if err != nil {
+		return
+	}
+	if !loaded {
+		err = errors.Newf("Could not load %s", file)
+	}
 	return
 }
 
@@ -64,8 +120,10 @@ func (self *cmd_load) Help(line []string) (result string, err error) {
 
 	result = fmt.Sprintf(`
 [33mload [remote][0m      [1mReplace[0m the local vault with the server's version.
-		   Note: in that case you will be asked for the new vault
-		   password (the previous vault is closed).
+		   You are asked for the encryption phrase of the remote
+		   vault; it is checked before replacing the local one
+		   (which is kept in the [33mbackups[0m). The loaded vault is
+		   then open, with that encryption phrase.
 		   [33m[remote][0m: see %s
 `,
 		remote_note,
@@ -41,10 +41,22 @@ func TestLoadRun1(t *testing.T) {
 	rmt := remote.NewMockRemote(ctrl)
 	rem.EXPECT().Remote("").Return(rmt, nil)
 
-	path := "vault_path"
-	cfg.EXPECT().VaultPath().Return(path, nil)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil)
+	xdg.EXPECT().RuntimeDir().Return("runtimeDir", nil)
 
-	rmt.EXPECT().LoadVault(path)
+	rmt.EXPECT().LoadVault("runtimeDir/load_vault").Return(nil)
+	rmt.EXPECT().AcceptVault()
+
+	pass := "remote pass"
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return(pass, nil)
+	args := server.VerifyArgs{Vault: "runtimeDir/load_vault", Master: pass}
+	srv.EXPECT().Verify(args, gomock.Any()).Do(func(_ server.VerifyArgs, reply *server.VerifyReport) {
+		*reply = server.VerifyReport{Keys: 1}
+	})
+	srv.EXPECT().Load(server.MergeArgs{Vault: "runtimeDir/load_vault", Master: pass}, gomock.Any()).Do(func(_ server.MergeArgs, reply *bool) {
+		*reply = true
+	})
 
 	err := load.Run([]string{"load"})
 	if err != nil {
@@ -66,13 +78,83 @@ func TestLoadRun2(t *testing.T) {
 	rmt := remote.NewMockRemote(ctrl)
 	rem.EXPECT().Remote("foo").Return(rmt, nil)
 
-	path := "vault_path"
-	cfg.EXPECT().VaultPath().Return(path, nil)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil)
+	xdg.EXPECT().RuntimeDir().Return("runtimeDir", nil)
+
+	rmt.EXPECT().LoadVault("runtimeDir/load_vault").Return(nil)
+	rmt.EXPECT().AcceptVault()
 
-	rmt.EXPECT().LoadVault(path)
+	pass := "remote pass"
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return(pass, nil)
+	srv.EXPECT().Verify(gomock.Any(), gomock.Any())
+	srv.EXPECT().Load(server.MergeArgs{Vault: "runtimeDir/load_vault", Master: pass}, gomock.Any()).Do(func(_ server.MergeArgs, reply *bool) {
+		*reply = true
+	})
 
 	err := load.Run([]string{"load", "foo"})
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+func TestLoadInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	load := &cmd_load{cmd, rem, srv, cfg, mmi}
+
+	rmt := remote.NewMockRemote(ctrl)
+	rem.EXPECT().Remote("").Return(rmt, nil)
+
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil)
+	xdg.EXPECT().RuntimeDir().Return("runtimeDir", nil)
+
+	rmt.EXPECT().LoadVault("runtimeDir/load_vault").Return(nil)
+
+	report := server.VerifyReport{Problems: []string{"Could not decrypt vault: bad master or corrupted vault"}}
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return("bad pass", nil)
+	srv.EXPECT().Verify(gomock.Any(), gomock.Any()).Do(func(_ server.VerifyArgs, reply *server.VerifyReport) {
+		*reply = report
+	})
+	mmi.EXPECT().Pager(verifyReport(report))
+
+	err := load.Run([]string{"load"})
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLoadCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	load := &cmd_load{cmd, rem, srv, cfg, mmi}
+
+	rmt := remote.NewMockRemote(ctrl)
+	rem.EXPECT().Remote("").Return(rmt, nil)
+
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil)
+	xdg.EXPECT().RuntimeDir().Return("runtimeDir", nil)
+
+	rmt.EXPECT().LoadVault("runtimeDir/load_vault").Return(nil)
+
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return("", nil)
+
+	err := load.Run([]string{"load"})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,54 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/core/errors"
+)
+
+type cmd_lock cmd
+
+var _ Command = &cmd_lock{}
+
+func (self *cmd_lock) Name() string {
+	return "lock"
+}
+
+func (self *cmd_lock) Run(line []string) (err error) {
+	var reply bool
+	err = self.server.Lock("console", &reply)
+	if err != nil {
+		return
+	}
+	if !reply {
+		err = errors.New("The server refused to lock the vault")
+	}
+	return
+}
+
+func (self *cmd_lock) Complete(line []string) (result []string, err error) {
+	return
+}
+
+func (self *cmd_lock) Help(line []string) (result string, err error) {
+
+	result = `
+[33mlock[0m		   Save and close the vault, without stopping the server.
+		   The encryption phrase will be asked again to use it.
+`
+
+	return
+}
@@ -22,6 +22,7 @@ import (
 
 import (
 	"fmt"
+	"strings"
 )
 
 type cmd_merge cmd
@@ -33,9 +34,15 @@ func (self *cmd_merge) Name() string {
 }
 
 func (self *cmd_merge) Run(line []string) (err error) {
+	args := line[1:]
+	dryRun := len(args) > 0 && args[0] == "--dry-run"
+	if dryRun {
+		args = args[1:]
+	}
+
 	var remoteName string
-	if len(line) > 1 {
-		remoteName = line[1]
+	if len(args) > 0 {
+		remoteName = args[0]
 	} else {
 		remoteName = ""
 	}
@@ -68,9 +75,16 @@ to the remote vault`)
 		return
 	}
 
-	if pass != "" {
+	if pass != "" && dryRun {
+		var diff server.MergeDiff
+		err = self.server.Diff(server.MergeArgs{Vault: merge_vault, Master: pass}, &diff)
+		if err != nil {
+			return
+		}
+		err = self.mmi.Pager(diffReport(diff))
+	} else if pass != "" {
 		var merged bool
-		err = self.server.Merge(server.MergeArgs{merge_vault, pass}, &merged)
+		err = self.server.Merge(server.MergeArgs{Vault: merge_vault, Master: pass}, &merged)
 		if err != nil {
 			return
 		}
@@ -79,6 +93,12 @@ to the remote vault`)
 			return
 		}
 
+		err = self.resolveConflicts()
+		if err != nil {
+			return
+		}
+		remote.AcceptVault()
+
 		cmd := self.commander.Command("save")
 		err = cmd.Run(line)
 		if err != nil {
@@ -97,10 +117,80 @@ to the remote vault`)
 	return
 }
 
+func diffReport(diff server.MergeDiff) string {
+	text := []string{}
+	for _, section := range []struct {
+		title string
+		keys  []string
+	}{
+		{"Added", diff.Added},
+		{"Updated", diff.Updated},
+		{"Deleted", diff.Deleted},
+		{"Conflicting", diff.Conflicting},
+	} {
+		if len(section.keys) > 0 {
+			text = append(text, fmt.Sprintf("[1m%s[0m", section.title))
+			for _, key := range section.keys {
+				text = append(text, "  "+key)
+			}
+		}
+	}
+	if len(text) == 0 {
+		text = append(text, "Nothing to merge")
+	}
+	text = append(text, "")
+	return strings.Join(text, "\n")
+}
+
+// Ask the user how to resolve each merge conflict
+func (self *cmd_merge) resolveConflicts() (err error) {
+	var conflicts []server.Conflict
+	err = self.server.Conflicts(".*", &conflicts)
+	if err != nil {
+		return
+	}
+
+	choices := []string{server.ResolveLocal, server.ResolveRemote, server.ResolveBoth}
+	for _, conflict := range conflicts {
+		var choice string
+		choice, err = self.mmi.Choose(fmt.Sprintf("%s was changed in both vaults (local: %s, remote: %s).\nKeep which password?",
+			conflict.Key, formatTime(conflict.LocalModified), formatTime(conflict.RemoteModified)), choices)
+		if err != nil {
+			// the server refuses to save until the merge is done again
+			return errors.Newf("Conflicts not resolved, the vault cannot be saved: merge again")
+		}
+		var resolved bool
+		err = self.server.Resolve(server.ResolveArgs{Key: conflict.Key, Choice: choice}, &resolved)
+		if err != nil {
+			return
+		}
+		if !resolved {
+			return errors.Newf("Could not resolve %s", conflict.Key)
+		}
+	}
+	return
+}
+
 func (self *cmd_merge) Complete(line []string) (result []string, err error) {
+	if len(line) == 2 && strings.HasPrefix("--dry-run", line[1]) {
+		result = []string{"--dry-run"}
+	}
 	return
 }
 
 func (self *cmd_merge) Help(line []string) (result string, err error) {
+
+	result = `
+[33mmerge [--dry-run] [remote][0m
+		   Merge the remote vault into the local one, then save
+		   the result both locally and to the remote.
+		   If a password was changed in both vaults, you are asked
+		   which one to keep: [33mlocal[0m, [33mremote[0m, or [33mboth[0m (the
+		   remote one is then kept as [33m<key>.conflict[0m).
+		   With [33m--dry-run[0m, only show the keys that would be
+		   added, updated, deleted, or in conflict; nothing is
+		   changed nor saved.
+`
+
 	return
 }
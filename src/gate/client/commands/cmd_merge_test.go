@@ -19,6 +19,7 @@ import (
 	"gate/client/remote"
 	"gate/client/ui"
 	"gate/core"
+	"gate/core/errors"
 	"gate/server"
 )
 
@@ -46,13 +47,15 @@ func TestMergeRun1(t *testing.T) {
 	xdg.EXPECT().RuntimeDir().Return("runtimeDir", nil)
 
 	rmt.EXPECT().LoadVault("runtimeDir/merge_vault").Return(nil)
+	rmt.EXPECT().AcceptVault()
 
 	pass := "remote pass"
 	mmi.EXPECT().ReadPassword(gomock.Any()).Return(pass, nil)
 
-	srv.EXPECT().Merge(server.MergeArgs{"runtimeDir/merge_vault", pass}, gomock.Any()).Do(func(_ server.MergeArgs, reply *bool) {
+	srv.EXPECT().Merge(server.MergeArgs{Vault: "runtimeDir/merge_vault", Master: pass}, gomock.Any()).Do(func(_ server.MergeArgs, reply *bool) {
 		*reply = true
 	})
+	srv.EXPECT().Conflicts(".*", gomock.Any())
 
 	save := NewMockCommand(ctrl)
 	cmd.EXPECT().Command("save").Return(save)
@@ -87,13 +90,15 @@ func TestMergeRun2(t *testing.T) {
 	xdg.EXPECT().RuntimeDir().Return("runtimeDir", nil)
 
 	rmt.EXPECT().LoadVault("runtimeDir/merge_vault").Return(nil)
+	rmt.EXPECT().AcceptVault()
 
 	pass := "remote pass"
 	mmi.EXPECT().ReadPassword(gomock.Any()).Return(pass, nil)
 
-	srv.EXPECT().Merge(server.MergeArgs{"runtimeDir/merge_vault", pass}, gomock.Any()).Do(func(_ server.MergeArgs, reply *bool) {
+	srv.EXPECT().Merge(server.MergeArgs{Vault: "runtimeDir/merge_vault", Master: pass}, gomock.Any()).Do(func(_ server.MergeArgs, reply *bool) {
 		*reply = true
 	})
+	srv.EXPECT().Conflicts(".*", gomock.Any())
 
 	save := NewMockCommand(ctrl)
 	cmd.EXPECT().Command("save").Return(save)
@@ -108,3 +113,141 @@ func TestMergeRun2(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestMergeRunConflicts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	merge := &cmd_merge{cmd, rem, srv, cfg, mmi}
+
+	rmt := remote.NewMockRemote(ctrl)
+	rem.EXPECT().Remote("").Return(rmt, nil)
+
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil)
+	xdg.EXPECT().RuntimeDir().Return("runtimeDir", nil)
+
+	rmt.EXPECT().LoadVault("runtimeDir/merge_vault").Return(nil)
+	rmt.EXPECT().AcceptVault()
+
+	pass := "remote pass"
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return(pass, nil)
+
+	srv.EXPECT().Merge(server.MergeArgs{Vault: "runtimeDir/merge_vault", Master: pass}, gomock.Any()).Do(func(_ server.MergeArgs, reply *bool) {
+		*reply = true
+	})
+	srv.EXPECT().Conflicts(".*", gomock.Any()).Do(func(_ string, reply *[]server.Conflict) {
+		*reply = []server.Conflict{{Key: "foo"}, {Key: "bar"}}
+	})
+	choices := []string{"local", "remote", "both"}
+	gomock.InOrder(
+		mmi.EXPECT().Choose(gomock.Any(), choices).Return("remote", nil),
+		mmi.EXPECT().Choose(gomock.Any(), choices).Return("both", nil),
+	)
+	srv.EXPECT().Resolve(server.ResolveArgs{Key: "foo", Choice: "remote"}, gomock.Any()).Do(func(_ server.ResolveArgs, reply *bool) {
+		*reply = true
+	})
+	srv.EXPECT().Resolve(server.ResolveArgs{Key: "bar", Choice: "both"}, gomock.Any()).Do(func(_ server.ResolveArgs, reply *bool) {
+		*reply = true
+	})
+
+	save := NewMockCommand(ctrl)
+	cmd.EXPECT().Command("save").Return(save)
+	save.EXPECT().Run([]string{"merge"})
+
+	path := "vault_path"
+	cfg.EXPECT().VaultPath().Return(path, nil)
+	rmt.EXPECT().SaveVault(path)
+
+	err := merge.Run([]string{"merge"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMergeRunCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	merge := &cmd_merge{cmd, rem, srv, cfg, mmi}
+
+	rmt := remote.NewMockRemote(ctrl)
+	rem.EXPECT().Remote("").Return(rmt, nil)
+
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil)
+	xdg.EXPECT().RuntimeDir().Return("runtimeDir", nil)
+
+	rmt.EXPECT().LoadVault("runtimeDir/merge_vault").Return(nil)
+
+	pass := "remote pass"
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return(pass, nil)
+
+	srv.EXPECT().Merge(server.MergeArgs{Vault: "runtimeDir/merge_vault", Master: pass}, gomock.Any()).Do(func(_ server.MergeArgs, reply *bool) {
+		*reply = true
+	})
+	srv.EXPECT().Conflicts(".*", gomock.Any()).Do(func(_ string, reply *[]server.Conflict) {
+		*reply = []server.Conflict{{Key: "foo"}}
+	})
+	mmi.EXPECT().Choose(gomock.Any(), gomock.Any()).Return("", errors.New("Cancelled"))
+
+	err := merge.Run([]string{"merge"})
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestMergeRunDryRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	merge := &cmd_merge{cmd, rem, srv, cfg, mmi}
+
+	rmt := remote.NewMockRemote(ctrl)
+	rem.EXPECT().Remote("foo").Return(rmt, nil)
+
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil)
+	xdg.EXPECT().RuntimeDir().Return("runtimeDir", nil)
+
+	rmt.EXPECT().LoadVault("runtimeDir/merge_vault").Return(nil)
+
+	pass := "remote pass"
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return(pass, nil)
+
+	srv.EXPECT().Diff(server.MergeArgs{Vault: "runtimeDir/merge_vault", Master: pass}, gomock.Any()).Do(func(_ server.MergeArgs, reply *server.MergeDiff) {
+		*reply = server.MergeDiff{
+			Added:       []string{"key1", "key2"},
+			Deleted:     []string{"key3"},
+			Conflicting: []string{"key4"},
+		}
+	})
+	mmi.EXPECT().Pager(`[1mAdded[0m
+  key1
+  key2
+[1mDeleted[0m
+  key3
+[1mConflicting[0m
+  key4
+`)
+
+	err := merge.Run([]string{"merge", "--dry-run", "foo"})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -50,6 +50,8 @@ func newRemote(host_commander Commander, remoter remote.Remoter, srv server.Serv
 	}
 
 	cmder.commands["list"] = &cmd_remote_list{cmder, remoter, srv, config, mmi}
+	cmder.commands["log"] = &cmd_remote_log{cmder, remoter, srv, config, mmi}
+	cmder.commands["restore"] = &cmd_remote_restore{cmder, remoter, srv, config, mmi}
 
 	return &cmd_remote{command, cmder}
 }
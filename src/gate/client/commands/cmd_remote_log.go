@@ -0,0 +1,100 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/client/remote"
+	"gate/core/errors"
+)
+
+import (
+	"fmt"
+	"strings"
+)
+
+type cmd_remote_log cmd
+
+var _ Command = &cmd_remote_log{}
+
+func (self *cmd_remote_log) Name() string {
+	return "log"
+}
+
+// The remote must keep the history of the vault (e.g. git)
+func historyRemote(remoter remote.Remoter, name string) (result remote.HistoryRemote, err error) {
+	rem, err := remoter.Remote(name)
+	if err != nil {
+		return
+	}
+	result, ok := rem.(remote.HistoryRemote)
+	if !ok {
+		err = errors.Newf("The remote %s does not keep the vault history", rem.Name())
+	}
+	return
+}
+
+func (self *cmd_remote_log) Run(line []string) (err error) {
+	var remoteName string
+	switch len(line) {
+	case 2:
+		remoteName = ""
+	case 3:
+		remoteName = line[2]
+	default:
+		return errors.New("Invalid arguments")
+	}
+
+	rem, err := historyRemote(self.remoter, remoteName)
+	if err != nil {
+		return
+	}
+
+	revisions, err := rem.Log()
+	if err != nil {
+		return
+	}
+	err = self.mmi.Pager(revisionsReport(revisions))
+	return
+}
+
+func revisionsReport(revisions []remote.Revision) string {
+	if len(revisions) == 0 {
+		return "No revisions\n"
+	}
+	text := []string{}
+	for _, revision := range revisions {
+		id := revision.Id
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		text = append(text, fmt.Sprintf("  %s  %s  %s", id, formatTime(revision.Date), revision.Message))
+	}
+	text = append(text, "")
+	return strings.Join(text, "\n")
+}
+
+func (self *cmd_remote_log) Complete(line []string) (result []string, err error) {
+	return
+}
+
+func (self *cmd_remote_log) Help(line []string) (result string, err error) {
+	result = `
+[33mremote log [remote][0m
+		   List the versions of the remote vault, most recent
+		   first, if the remote keeps them ([33mmethod = git[0m).
+`
+	return
+}
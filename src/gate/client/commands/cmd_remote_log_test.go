@@ -0,0 +1,84 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/client/remote"
+	"gate/client/ui"
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"testing"
+	"time"
+)
+
+var testRevisions = []remote.Revision{
+	{Id: "0123456789abcdef0123456789abcdef01234567", Date: time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC), Message: "Vault saved on box"},
+	{Id: "fedcba9876543210fedcba9876543210fedcba98", Date: time.Date(2015, 1, 1, 3, 4, 5, 0, time.UTC), Message: "Vault created on box"},
+}
+
+func TestRemoteLog(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	log := &cmd_remote_log{cmd, rem, srv, cfg, mmi}
+
+	rmt := remote.NewMockHistoryRemote(ctrl)
+	rem.EXPECT().Remote("foo").Return(rmt, nil)
+	rmt.EXPECT().Log().Return(testRevisions, nil)
+	mmi.EXPECT().Pager(revisionsReport(testRevisions))
+
+	err := log.Run([]string{"remote", "log", "foo"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	report := revisionsReport(testRevisions)
+	expected := "  0123456789ab  " + formatTime(testRevisions[0].Date) + "  Vault saved on box\n" +
+		"  fedcba987654  " + formatTime(testRevisions[1].Date) + "  Vault created on box\n"
+	if report != expected {
+		t.Errorf("unexpected report: %q", report)
+	}
+}
+
+func TestRemoteLogNoHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	log := &cmd_remote_log{cmd, rem, srv, cfg, mmi}
+
+	rmt := remote.NewMockRemote(ctrl)
+	rem.EXPECT().Remote("").Return(rmt, nil)
+	rmt.EXPECT().Name().Return("foo")
+
+	err := log.Run([]string{"remote", "log"})
+	if err == nil {
+		t.Error("expected error: the remote does not keep the history")
+	}
+}
@@ -0,0 +1,90 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/core/errors"
+)
+
+import (
+	"fmt"
+	"os"
+)
+
+type cmd_remote_restore cmd
+
+var _ Command = &cmd_remote_restore{}
+
+func (self *cmd_remote_restore) Name() string {
+	return "restore"
+}
+
+func (self *cmd_remote_restore) Run(line []string) (err error) {
+	var revision, remoteName string
+	switch len(line) {
+	case 3:
+		revision, remoteName = line[2], ""
+	case 4:
+		revision, remoteName = line[2], line[3]
+	default:
+		return errors.New("Invalid arguments")
+	}
+
+	rem, err := historyRemote(self.remoter, remoteName)
+	if err != nil {
+		return
+	}
+
+	xdg, err := self.config.Xdg()
+	if err != nil {
+		return
+	}
+
+	dir, err := xdg.RuntimeDir()
+	if err != nil {
+		return
+	}
+
+	restore_vault := fmt.Sprintf("%s/restore_vault", dir)
+	defer os.Remove(restore_vault)
+
+	err = rem.Restore(revision, restore_vault)
+	if err != nil {
+		return
+	}
+
+	loaded, err := loadVault(self.server, self.mmi, restore_vault)
+	if err == nil && loaded {
+		rem.AcceptVault()
+	}
+	return
+}
+
+func (self *cmd_remote_restore) Complete(line []string) (result []string, err error) {
+	return
+}
+
+func (self *cmd_remote_restore) Help(line []string) (result string, err error) {
+	result = `
+[33mremote restore <rev> [remote][0m
+		   [1mReplace[0m the local vault with a past version of the
+		   remote vault, as listed by [33mremote log[0m. Like [33mload[0m,
+		   the encryption phrase of that version is asked and
+		   checked first, and the local vault is kept in the
+		   [33mbackups[0m. Use [33msave[0m to make it the remote vault again.
+`
+	return
+}
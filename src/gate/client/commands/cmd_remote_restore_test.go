@@ -0,0 +1,79 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/client/remote"
+	"gate/client/ui"
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"testing"
+)
+
+func TestRemoteRestore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	restore := &cmd_remote_restore{cmd, rem, srv, cfg, mmi}
+
+	rmt := remote.NewMockHistoryRemote(ctrl)
+	rem.EXPECT().Remote("foo").Return(rmt, nil)
+
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil)
+	xdg.EXPECT().RuntimeDir().Return("runtimeDir", nil)
+
+	rmt.EXPECT().Restore("0123abcd", "runtimeDir/restore_vault").Return(nil)
+	rmt.EXPECT().AcceptVault()
+
+	pass := "old pass"
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return(pass, nil)
+	srv.EXPECT().Verify(server.VerifyArgs{Vault: "runtimeDir/restore_vault", Master: pass}, gomock.Any())
+	srv.EXPECT().Load(server.MergeArgs{Vault: "runtimeDir/restore_vault", Master: pass}, gomock.Any()).Do(func(_ server.MergeArgs, reply *bool) {
+		*reply = true
+	})
+
+	err := restore.Run([]string{"remote", "restore", "0123abcd", "foo"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoteRestoreMissingRevision(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	restore := &cmd_remote_restore{cmd, rem, srv, cfg, mmi}
+
+	err := restore.Run([]string{"remote", "restore"})
+	if err == nil {
+		t.Error("expected error: missing revision")
+	}
+}
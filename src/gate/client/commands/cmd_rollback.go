@@ -0,0 +1,79 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type cmd_rollback cmd
+
+var _ Command = &cmd_rollback{}
+
+func (self *cmd_rollback) Name() string {
+	return "rollback"
+}
+
+func (self *cmd_rollback) Run(line []string) (err error) {
+	args := server.RollbackArgs{Version: 1}
+	switch len(line) {
+	case 2:
+		args.Key = line[1]
+	case 3:
+		args.Key = line[1]
+		args.Version, err = strconv.Atoi(line[2])
+		if err != nil {
+			return errors.Newf("Invalid version: '%s'", line[2])
+		}
+	default:
+		return errors.New("Invalid arguments")
+	}
+
+	var pass string
+	err = self.server.Rollback(args, &pass)
+	if err != nil {
+		return
+	}
+
+	err = self.mmi.Xclip(pass)
+	return
+}
+
+func (self *cmd_rollback) Complete(line []string) (result []string, err error) {
+	if len(line) == 2 {
+		err = self.server.List(fmt.Sprintf("^%s", line[1]), &result)
+	}
+	return
+}
+
+func (self *cmd_rollback) Help(line []string) (result string, err error) {
+
+	result = `
+[33mrollback <key> [n][0m
+		   Restore a previous password of the given key: [33mn[0m is the
+		   number shown by [33mhistory[0m (default 1, the most recent).
+		   The replaced password is kept in the history.
+		   The restored password is stored in the clipboard.
+`
+
+	return
+}
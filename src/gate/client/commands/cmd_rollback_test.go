@@ -0,0 +1,89 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/client/remote"
+	"gate/client/ui"
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"testing"
+)
+
+func TestRollbackRun1(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	rollback := &cmd_rollback{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().Rollback(server.RollbackArgs{Key: "foo", Version: 1}, gomock.Any()).Do(func(_ server.RollbackArgs, reply *string) {
+		*reply = "old"
+	})
+	mmi.EXPECT().Xclip("old")
+
+	err := rollback.Run([]string{"rollback", "foo"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRollbackRun2(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	rollback := &cmd_rollback{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().Rollback(server.RollbackArgs{Key: "foo", Version: 3}, gomock.Any()).Do(func(_ server.RollbackArgs, reply *string) {
+		*reply = "older"
+	})
+	mmi.EXPECT().Xclip("older")
+
+	err := rollback.Run([]string{"rollback", "foo", "3"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRollbackRunInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	rollback := &cmd_rollback{cmd, rem, srv, cfg, mmi}
+
+	err := rollback.Run([]string{"rollback", "foo", "last"})
+	if err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,93 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type cmd_set cmd
+
+var _ Command = &cmd_set{}
+
+func (self *cmd_set) Name() string {
+	return "set"
+}
+
+func (self *cmd_set) Run(line []string) (err error) {
+	if len(line) < 3 {
+		return errors.New("Invalid arguments")
+	}
+	args := server.SetFieldArgs{
+		Key:   line[1],
+		Field: line[2],
+		Value: strings.Join(line[3:], " "),
+	}
+	var ok bool
+	err = self.server.SetField(args, &ok)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = errors.Newf("Could not set %s of %s", args.Field, args.Key)
+	}
+	return
+}
+
+func (self *cmd_set) Complete(line []string) (result []string, err error) {
+	switch len(line) {
+	case 2:
+		err = self.server.List(fmt.Sprintf("^%s", line[1]), &result)
+	case 3:
+		var info server.KeyInfo
+		err = self.server.Info(server.InfoArgs{Key: line[1]}, &info)
+		if err != nil {
+			return
+		}
+		fields := []string{"notes", "url", "username"}
+		for name := range info.Fields {
+			fields = append(fields, name)
+		}
+		sort.Strings(fields)
+		result = make([]string, 0, len(fields))
+		for _, field := range fields {
+			if strings.HasPrefix(field, line[2]) {
+				result = append(result, field)
+			}
+		}
+	}
+	return
+}
+
+func (self *cmd_set) Help(line []string) (result string, err error) {
+
+	result = `
+[33mset <key> <field> [value][0m
+		   Set a field of the given key: [33musername[0m, [33murl[0m,
+		   [33mnotes[0m, or any other field name.
+		   Without value, the field is removed.
+		   Use [33madd[0m to change the password.
+`
+
+	return
+}
@@ -0,0 +1,95 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/client/remote"
+	"gate/client/ui"
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"reflect"
+	"testing"
+)
+
+func TestSetRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	set := &cmd_set{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().SetField(server.SetFieldArgs{Key: "foo", Field: "notes", Value: "some notes"}, gomock.Any()).Do(func(_ server.SetFieldArgs, reply *bool) {
+		*reply = true
+	})
+
+	err := set.Run([]string{"set", "foo", "notes", "some", "notes"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetRunRemove(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	set := &cmd_set{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().SetField(server.SetFieldArgs{Key: "foo", Field: "url", Value: ""}, gomock.Any()).Do(func(_ server.SetFieldArgs, reply *bool) {
+		*reply = true
+	})
+
+	err := set.Run([]string{"set", "foo", "url"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetComplete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	set := &cmd_set{cmd, rem, srv, cfg, mmi}
+
+	srv.EXPECT().Info(server.InfoArgs{Key: "foo"}, gomock.Any()).Do(func(_ server.InfoArgs, reply *server.KeyInfo) {
+		*reply = server.KeyInfo{Name: "foo", Fields: map[string]string{"pin": "1234"}}
+	})
+
+	result, err := set.Complete([]string{"set", "foo", "u"})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(result, []string{"url", "username"}) {
+		t.Errorf("bad completion: %v", result)
+	}
+}
@@ -0,0 +1,105 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+type cmd_verify cmd
+
+var _ Command = &cmd_verify{}
+
+func (self *cmd_verify) Name() string {
+	return "verify"
+}
+
+func (self *cmd_verify) Run(line []string) (err error) {
+	args := server.VerifyArgs{}
+	switch len(line) {
+	case 1:
+		// the server vault
+	case 2:
+		// the server may not run in the same directory
+		args.Vault, err = filepath.Abs(line[1])
+		if err != nil {
+			return errors.Decorated(err)
+		}
+	default:
+		return errors.New("Invalid arguments")
+	}
+
+	args.Master, err = self.mmi.ReadPassword(`Please enter the encryption phrase
+to the vault to verify`)
+	if err != nil || args.Master == "" {
+		return
+	}
+
+	var report server.VerifyReport
+	err = self.server.Verify(args, &report)
+	if err != nil {
+		return
+	}
+
+	err = self.mmi.Pager(verifyReport(report))
+	return
+}
+
+func verifyReport(report server.VerifyReport) string {
+	text := []string{}
+	if report.Cipher != "" {
+		text = append(text, fmt.Sprintf("Cipher: %s, records: %s", report.Cipher, report.Records))
+	}
+	if len(report.Problems) == 0 {
+		text = append(text, fmt.Sprintf("[1mValid vault[0m: %d keys (and %d deleted)", report.Keys, report.Deleted))
+	} else {
+		text = append(text, fmt.Sprintf("[1mInvalid vault[0m: %d problem(s)", len(report.Problems)))
+		for _, problem := range report.Problems {
+			text = append(text, "  "+problem)
+		}
+	}
+	text = append(text, "")
+	return strings.Join(text, "\n")
+}
+
+func (self *cmd_verify) Complete(line []string) (result []string, err error) {
+	if len(line) == 2 {
+		result, err = filepath.Glob(line[1] + "*")
+		if err != nil {
+			err = errors.Decorated(err)
+		}
+	}
+	return
+}
+
+func (self *cmd_verify) Help(line []string) (result string, err error) {
+
+	result = `
+[33mverify [path][0m      Check a vault file (by default, the local vault)
+		   without opening it: its encryption phrase is asked,
+		   then all its records are decoded. Malformed records,
+		   duplicate keys, and decryption failures are reported.
+`
+
+	return
+}
@@ -0,0 +1,97 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"gate/client/remote"
+	"gate/client/ui"
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"os"
+	"testing"
+)
+
+func TestVerifyRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	verify := &cmd_verify{cmd, rem, srv, cfg, mmi}
+
+	report := server.VerifyReport{Cipher: "aes-256-gcm", Records: "scrypt", Keys: 2, Problems: []string{"line 3: malformed record"}}
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return("secret", nil)
+	srv.EXPECT().Verify(server.VerifyArgs{Master: "secret"}, gomock.Any()).Do(func(_ server.VerifyArgs, reply *server.VerifyReport) {
+		*reply = report
+	})
+	mmi.EXPECT().Pager(verifyReport(report))
+
+	err := verify.Run([]string{"verify"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyRunPath(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	verify := &cmd_verify{cmd, rem, srv, cfg, mmi}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return("secret", nil)
+	srv.EXPECT().Verify(server.VerifyArgs{Vault: dir + "/old_vault", Master: "secret"}, gomock.Any())
+	mmi.EXPECT().Pager(gomock.Any())
+
+	err = verify.Run([]string{"verify", "old_vault"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyRunCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cmd := NewMockCommander(ctrl)
+	rem := remote.NewMockRemoter(ctrl)
+	srv := server.NewMockServer(ctrl)
+	cfg := core.NewMockConfig(ctrl)
+	mmi := ui.NewMockUserInteraction(ctrl)
+	verify := &cmd_verify{cmd, rem, srv, cfg, mmi}
+
+	mmi.EXPECT().ReadPassword(gomock.Any()).Return("", nil)
+
+	err := verify.Run([]string{"verify"})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -69,16 +69,23 @@ func NewCommander(remoter remote.Remoter, srv server.Server, config core.Config,
 	result = cmd
 
 	cmd.commands["add"] = &cmd_add{result, remoter, srv, config, mmi}
+	cmd.commands["backups"] = &cmd_backups{result, remoter, srv, config, mmi}
 	cmd.commands["del"] = &cmd_del{result, remoter, srv, config, mmi}
 	cmd.commands["help"] = &cmd_help{result, remoter, srv, config, mmi}
+	cmd.commands["history"] = &cmd_history{result, remoter, srv, config, mmi}
+	cmd.commands["info"] = &cmd_info{result, remoter, srv, config, mmi}
 	cmd.commands["list"] = &cmd_list{result, remoter, srv, config, mmi}
 	cmd.commands["load"] = &cmd_load{result, remoter, srv, config, mmi}
+	cmd.commands["lock"] = &cmd_lock{result, remoter, srv, config, mmi}
 	cmd.commands["master"] = &cmd_master{result, remoter, srv, config, mmi}
 	cmd.commands["merge"] = &cmd_merge{result, remoter, srv, config, mmi}
 	cmd.commands["remote"] = newRemote(result, remoter, srv, config, mmi)
+	cmd.commands["rollback"] = &cmd_rollback{result, remoter, srv, config, mmi}
 	cmd.commands["save"] = &cmd_save{result, remoter, srv, config, mmi}
+	cmd.commands["set"] = &cmd_set{result, remoter, srv, config, mmi}
 	cmd.commands["show"] = &cmd_show{result, remoter, srv, config, mmi}
 	cmd.commands["stop"] = &cmd_stop{result, remoter, srv, config, mmi}
+	cmd.commands["verify"] = &cmd_verify{result, remoter, srv, config, mmi}
 	cmd.commands["get"] = &cmd_get{result, remoter, srv, config, mmi}
 
 	return
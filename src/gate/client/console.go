@@ -75,6 +75,13 @@ func (self *readline) loop(config core.Config) (err error) {
 		line, err = self.state.Prompt("> ")
 		if err == nil && len(line) > 0 {
 			err = self.run(strings.Split(line, " "))
+			if server.IsLocked(err) {
+				fmt.Println("The vault was locked.")
+				err = openVault(self.server, config)
+				if err == nil {
+					err = self.run(strings.Split(line, " "))
+				}
+			}
 			if err != nil {
 				e, ok := err.(errors.StackError)
 				if !ok {
@@ -119,6 +119,13 @@ func Menu(config core.Config) (err error) {
 	}
 	var list []string
 	err = srv.List(".*", &list)
+	if server.IsLocked(err) {
+		err = openVault(srv, config)
+		if err != nil {
+			return
+		}
+		err = srv.List(".*", &list)
+	}
 	if err != nil {
 		return errors.Decorated(err)
 	}
@@ -21,18 +21,16 @@ import (
 	"gate/client/ui"
 	"gate/core"
 	"gate/core/errors"
-	"gate/core/exec"
 	"gate/server"
+	"gate/server/daemon"
 	serverimpl "gate/server/impl"
 )
 
 import (
 	"fmt"
-	"io"
 	"os"
 	osexec "os/exec"
 	"strings"
-	"time"
 )
 
 var _proxy server.Server
@@ -45,50 +43,22 @@ func dirname() (result string) {
 	return
 }
 
-func startServer() (err error) {
-	pipe := make(chan io.WriteCloser, 1)
-
-	prepare := func(cmd *exec.Cmd) (err error) {
-		p, err := cmd.StdinPipe()
+func startServer(config core.Config) (err error) {
+	dir := dirname()
+	var exe string
+	if dir == "" {
+		exe, err = osexec.LookPath("server")
 		if err != nil {
 			return errors.Decorated(err)
 		}
-		pipe <- p
-		return
-	}
-
-	run := func(cmd *exec.Cmd) (err error) {
-		p := <-pipe
-		dir := dirname()
-		var exe string
-		if dir == "" {
-			exe, err = osexec.LookPath("server")
-			if err != nil {
-				return errors.Decorated(err)
-			}
-		} else {
-			exe = fmt.Sprintf("%s/server", dir)
-		}
-		var rc string
-		if len(os.Args) > 1 {
-			rc = os.Args[1]
-		}
-
-		p.Write([]byte(fmt.Sprintf("#!/bin/bash\n%s \"%s\" > /tmp/server-%s.log 2>&1 & disown\n", exe, rc, time.Now().Format("20060102150405"))))
-
-		err = p.Close()
-		if err != nil {
-			return errors.Decorated(err)
-		}
-		return
+	} else {
+		exe = fmt.Sprintf("%s/server", dir)
 	}
-
-	err = exec.Command(prepare, run, "at", "now")
-	if err != nil {
-		return
+	var args []string
+	if len(os.Args) > 1 {
+		args = append(args, os.Args[1])
 	}
-
-	return
+	return daemon.Start(config, exe, args...)
 }
 
 func readNewMaster(mmi ui.UserInteraction, reason string) (result string, err error) {
@@ -127,7 +97,10 @@ func openVault(srv server.Server, config core.Config) (err error) {
 	vault_path := fmt.Sprintf("%s/vault", data_home)
 	vault_info, err := os.Stat(vault_path)
 	if err != nil {
-		return errors.Decorated(err)
+		if !os.IsNotExist(err) {
+			return errors.Decorated(err)
+		}
+		err = nil
 	}
 
 	mmi, err := ui.Ui(srv, config)
@@ -163,7 +136,10 @@ func openVault(srv server.Server, config core.Config) (err error) {
 func proxy(config core.Config) (result server.Server, err error) {
 	result = _proxy
 	if result == nil {
-		s, err := serverimpl.Proxy(config, startServer)
+		var s server.Server
+		s, err = serverimpl.Proxy(config, func() error {
+			return startServer(config)
+		})
 		if err != nil {
 			return
 		}
@@ -0,0 +1,167 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+// HTTP authentication (basic and digest), answering the server
+// challenges
+
+import (
+	"gate/core/errors"
+)
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Set the credentials of the request, according to the challenges
+// sent by the server (WWW-Authenticate headers). Digest is preferred.
+func authorize(req *http.Request, challenges []string, user, pass string) error {
+	basic := false
+	for _, challenge := range challenges {
+		scheme, params := parseChallenge(challenge)
+		switch strings.ToLower(scheme) {
+		case "digest":
+			return digestAuthorize(req, params, user, pass)
+		case "basic":
+			basic = true
+		}
+	}
+	if !basic {
+		return errors.Newf("Unsupported authentication: '%s'", strings.Join(challenges, "', '"))
+	}
+	req.SetBasicAuth(user, pass)
+	return nil
+}
+
+// Split a challenge into its scheme and parameters (the values may be
+// quoted)
+func parseChallenge(challenge string) (scheme string, params map[string]string) {
+	challenge = strings.TrimSpace(challenge)
+	params = make(map[string]string)
+	i := strings.IndexByte(challenge, ' ')
+	if i < 0 {
+		return challenge, params
+	}
+	scheme = challenge[:i]
+	rest := challenge[i+1:]
+	for {
+		rest = strings.TrimLeft(rest, " \t,")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimLeft(rest[eq+1:], " \t")
+		var value []byte
+		if strings.HasPrefix(rest, `"`) {
+			j := 1
+			for ; j < len(rest) && rest[j] != '"'; j++ {
+				if rest[j] == '\\' && j+1 < len(rest) {
+					j++
+				}
+				value = append(value, rest[j])
+			}
+			if j < len(rest) {
+				j++
+			}
+			rest = rest[j:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value = []byte(strings.TrimSpace(rest[:end]))
+			rest = rest[end:]
+		}
+		params[key] = string(value)
+	}
+	return
+}
+
+func quote(value string) string {
+	return `"` + strings.Replace(strings.Replace(value, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+// RFC 7616 digest, with the "auth" quality of protection if the
+// server offers it
+func digestAuthorize(req *http.Request, params map[string]string, user, pass string) (err error) {
+	var newHash func() hash.Hash
+	algorithm := params["algorithm"]
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return errors.Newf("Unsupported digest algorithm: %s", algorithm)
+	}
+	digest := func(parts ...string) string {
+		h := newHash()
+		io.WriteString(h, strings.Join(parts, ":"))
+		return fmt.Sprintf("%x", h.Sum(nil))
+	}
+
+	realm := params["realm"]
+	nonce := params["nonce"]
+	uri := req.URL.RequestURI()
+	ha1 := digest(user, realm, pass)
+	ha2 := digest(req.Method, uri)
+
+	fields := []string{
+		"username=" + quote(user),
+		"realm=" + quote(realm),
+		"nonce=" + quote(nonce),
+		"uri=" + quote(uri),
+	}
+	qop := false
+	for _, q := range strings.Split(params["qop"], ",") {
+		qop = qop || strings.TrimSpace(q) == "auth"
+	}
+	if qop {
+		random := make([]byte, 16)
+		_, err = io.ReadFull(rand.Reader, random)
+		if err != nil {
+			return errors.Decorated(err)
+		}
+		cnonce := fmt.Sprintf("%x", random)
+		const nc = "00000001"
+		fields = append(fields,
+			"qop=auth",
+			"nc="+nc,
+			"cnonce="+quote(cnonce),
+			"response="+quote(digest(ha1, nonce, nc, cnonce, "auth", ha2)),
+		)
+	} else if params["qop"] != "" {
+		return errors.Newf("Unsupported digest qop: %s", params["qop"])
+	} else {
+		fields = append(fields, "response="+quote(digest(ha1, nonce, ha2)))
+	}
+	if algorithm != "" {
+		fields = append(fields, "algorithm="+algorithm)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		fields = append(fields, "opaque="+quote(opaque))
+	}
+	req.Header.Set("Authorization", "Digest "+strings.Join(fields, ", "))
+	return
+}
@@ -25,9 +25,11 @@ import (
 )
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
 type curl remote
@@ -52,6 +54,8 @@ func newCurl(name string, srv server.Server, config core.Config, remoter Remoter
 		remoter,
 		name,
 		nil,
+		"",
+		"",
 	}
 	file := name + ".rc"
 	for key, mandatory := range CurlAllowedKeys {
@@ -63,6 +67,11 @@ func newCurl(name string, srv server.Server, config core.Config, remoter Remoter
 			result.properties.setProperty(key, value)
 		}
 	}
+	proxy, err := newProxy(name, srv, config)
+	if err != nil {
+		return nil, err
+	}
+	result.proxy = proxy
 	return result, nil
 }
 
@@ -76,7 +85,8 @@ func (self *curl) arguments(option, file, request string) (result []string, err
 		err = errors.Newf("missing remote vault url")
 		return
 	}
-	result = []string{"-#", option, file, url}
+	// -f: an http error is not a vault
+	result = []string{"-#", "-f", option, file, url}
 	user := self.getProperty("user")
 	passkey := self.getProperty("passkey")
 	if user != "" {
@@ -97,18 +107,22 @@ func (self *curl) arguments(option, file, request string) (result []string, err
 	return
 }
 
-func (self *curl) doCurl(option, file, request string) (err error) {
+func (self *curl) doCurl(option, file, request string, stdout io.Writer, extra ...string) (err error) {
 	args, err := self.arguments(option, file, request)
 	if err != nil {
 		return
 	}
+	args = append(args, extra...)
 
 	prepare := func(cmd *exec.Cmd) (err error) {
 		if self.proxy != nil {
-			self.proxy.Install(cmd)
+			err = self.proxy.Install(cmd)
+			if err != nil {
+				return
+			}
 		}
 		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = stdout
 		cmd.Stderr = os.Stderr
 		return
 	}
@@ -118,12 +132,41 @@ func (self *curl) doCurl(option, file, request string) (err error) {
 	return
 }
 
-func (self *curl) LoadVault(file string) error {
-	return self.doCurl("-o", file, self.getProperty("get_request"))
+// Download the remote vault; -f fails on any http error, the status
+// tells whether the remote vault does not exist
+func (self *curl) load(file string) (err error) {
+	var status bytes.Buffer
+	err = self.doCurl("-o", file, self.getProperty("get_request"), &status, "-w", "%{http_code}")
+	if err != nil && strings.TrimSpace(status.String()) == "404" {
+		err = vaultNotFound(self.getProperty("url"))
+	}
+	return
+}
+
+func (self *curl) LoadVault(file string) (err error) {
+	err = self.load(file)
+	if err != nil {
+		return
+	}
+	self.loaded, err = fileVersion(file)
+	return
+}
+
+func (self *curl) AcceptVault() {
+	self.version = self.loaded
 }
 
-func (self *curl) SaveVault(file string) error {
-	return self.doCurl("-T", file, self.getProperty("put_request"))
+func (self *curl) SaveVault(file string) (err error) {
+	err = checkVersion(self.name, self.version, self.load, file)
+	if err != nil {
+		return
+	}
+	err = self.doCurl("-T", file, self.getProperty("put_request"), os.Stdout)
+	if err != nil {
+		return
+	}
+	self.version, err = fileVersion(file)
+	return
 }
 
 func (self *curl) Proxy() Proxy {
@@ -0,0 +1,212 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+// File remote: the vault is copied to and from a directory (a mounted
+// USB key, a synced folder...) without any external tool
+
+import (
+	"gate/core"
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type file remote
+
+var _ Remote = &file{}
+
+var FileAllowedKeys map[string]bool = map[string]bool{
+	"file": true,
+}
+
+func newFile(name string, srv server.Server, config core.Config, remoter Remoter) (Remote, error) {
+	result := &file{
+		properties{
+			allowed:    FileAllowedKeys,
+			properties: make(map[string]string),
+		},
+		srv,
+		remoter,
+		name,
+		nil,
+		"",
+		"",
+	}
+	rcfile := name + ".rc"
+	for key, mandatory := range FileAllowedKeys {
+		value, err := config.Eval(rcfile, "remote", key, os.Getenv)
+		if err != nil && mandatory {
+			return nil, err
+		}
+		if value != "" {
+			result.properties.setProperty(key, value)
+		}
+	}
+	return result, nil
+}
+
+func (self *file) Name() string {
+	return self.name
+}
+
+func (self *file) remoteFile() (result string, err error) {
+	result = self.getProperty("file")
+	if result == "" {
+		err = errors.Newf("missing remote vault file")
+	}
+	return
+}
+
+// Copy the source file over the target one
+func copyFile(source, target string) (err error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	defer in.Close()
+	return writeFile(target, in)
+}
+
+// Write the data over the target file: it is written next to the
+// target, synced, then renamed
+func writeFile(target string, in io.Reader) (err error) {
+	out, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".")
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	defer os.Remove(out.Name()) // no-op once renamed
+
+	err = out.Chmod(0600)
+	if err == nil {
+		_, err = io.Copy(out, in)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	e := out.Close()
+	if err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), target)
+	}
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	return
+}
+
+func (self *file) LoadVault(file string) (err error) {
+	remote_file, err := self.remoteFile()
+	if err != nil {
+		return
+	}
+	_, err = os.Stat(remote_file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return vaultNotFound(remote_file)
+		}
+		return errors.Decorated(err)
+	}
+	err = copyFile(remote_file, file)
+	if err != nil {
+		return
+	}
+	self.loaded, err = fileVersion(file)
+	return
+}
+
+func (self *file) AcceptVault() {
+	self.version = self.loaded
+}
+
+func (self *file) SaveVault(file string) (err error) {
+	remote_file, err := self.remoteFile()
+	if err != nil {
+		return
+	}
+	// never created: a missing directory is likely a device not mounted
+	dir := filepath.Dir(remote_file)
+	info, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Newf("remote directory not found: %s", dir)
+		}
+		return errors.Decorated(err)
+	}
+	if !info.IsDir() {
+		return errors.Newf("not a directory: %s", dir)
+	}
+	_, err = os.Stat(remote_file)
+	if err == nil {
+		var current string
+		current, err = fileVersion(remote_file)
+		if err != nil {
+			return uncheckedError(self.name, err)
+		}
+		if self.version == "" {
+			err = checkSame(self.name, current, file)
+		} else if current != self.version {
+			err = changedError(self.name)
+		}
+	} else if !os.IsNotExist(err) {
+		err = uncheckedError(self.name, err)
+	} else if self.version != "" {
+		// removed since it was loaded
+		err = changedError(self.name)
+	} else {
+		// no remote vault yet
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	err = copyFile(file, remote_file)
+	if err != nil {
+		return
+	}
+	self.version, err = fileVersion(file)
+	return
+}
+
+func (self *file) Proxy() Proxy {
+	return self.proxy
+}
+
+func (self *file) SetProperty(key, value string) error {
+	return self.setProperty(key, value)
+}
+
+func (self *file) ResetProperty(key string) error {
+	return self.resetProperty(key)
+}
+
+func (self *file) StoreProperties(out io.Writer) (err error) {
+	_, err = out.Write([]byte("[remote]\nmethod = file\n"))
+	if err != nil {
+		return errors.Decorated(err)
+	}
+
+	err = self.storeProperties(out)
+	return
+}
@@ -0,0 +1,215 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+import (
+	"gate/core"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileRemote(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "usb"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote_file := filepath.Join(dir, "usb", "vault")
+	local_file := filepath.Join(dir, "vault")
+
+	cfg := core.NewMockConfig(ctrl)
+	cfg.EXPECT().Eval("usb.rc", "remote", "method", nil).Return("file", nil)
+	cfg.EXPECT().Eval("usb.rc", "remote", "file", gomock.Any()).Return(remote_file, nil)
+
+	rem, err := NewRemoter(nil, cfg).Remote("usb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rem.LoadVault(local_file)
+	if err == nil {
+		t.Error("expected error: no remote vault yet")
+	}
+
+	err = ioutil.WriteFile(local_file, []byte("local"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rem.SaveVault(local_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(remote_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "local" {
+		t.Errorf("bad saved vault: '%s'", data)
+	}
+
+	err = ioutil.WriteFile(remote_file, []byte("remote"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rem.LoadVault(local_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(local_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "remote" {
+		t.Errorf("bad loaded vault: '%s'", data)
+	}
+
+	// no temporary file left
+	for _, d := range []string{dir, filepath.Join(dir, "usb")} {
+		files, err := filepath.Glob(filepath.Join(d, ".vault.*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 0 {
+			t.Errorf("temporary files left: %v", files)
+		}
+	}
+}
+
+func TestFileRemoteNotMounted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	local_file := filepath.Join(dir, "vault")
+	err = ioutil.WriteFile(local_file, []byte("local"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rem := &file{properties: properties{allowed: FileAllowedKeys, properties: map[string]string{"file": filepath.Join(dir, "usb", "vault")}}}
+	err = rem.SaveVault(local_file)
+	if err == nil {
+		t.Error("expected error")
+	}
+	_, err = os.Stat(filepath.Join(dir, "usb"))
+	if !os.IsNotExist(err) {
+		t.Errorf("remote directory created: %v", err)
+	}
+}
+
+func TestFileRemoteNotMerged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	remote_file := filepath.Join(dir, "remote")
+	local_file := filepath.Join(dir, "vault")
+	err = ioutil.WriteFile(remote_file, []byte("remote"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(local_file, []byte("local"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// never loaded
+	rem := &file{properties: properties{allowed: FileAllowedKeys, properties: map[string]string{"file": remote_file}}, name: "usb"}
+	err = rem.SaveVault(local_file)
+	if err == nil {
+		t.Error("expected error")
+	}
+	checkFile(t, remote_file, "remote")
+
+	// loaded but not merged (e.g. merge --dry-run)
+	other_file := filepath.Join(dir, "other")
+	err = rem.LoadVault(other_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rem.SaveVault(local_file)
+	if err == nil {
+		t.Error("expected error")
+	}
+	checkFile(t, remote_file, "remote")
+}
+
+func TestFileRemoteChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	remote_file := filepath.Join(dir, "remote")
+	local_file := filepath.Join(dir, "vault")
+	err = ioutil.WriteFile(remote_file, []byte("remote"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rem := &file{properties: properties{allowed: FileAllowedKeys, properties: map[string]string{"file": remote_file}}, name: "usb"}
+	err = rem.LoadVault(local_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem.AcceptVault()
+
+	// another machine merged in between
+	err = ioutil.WriteFile(remote_file, []byte("other"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rem.SaveVault(local_file)
+	if err == nil {
+		t.Error("expected error")
+	}
+	checkFile(t, remote_file, "other")
+
+	// merge again
+	err = rem.LoadVault(local_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem.AcceptVault()
+	err = ioutil.WriteFile(local_file, []byte("merged"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rem.SaveVault(local_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, remote_file, "merged")
+}
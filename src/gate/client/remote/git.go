@@ -0,0 +1,442 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+// Git remote: the vault is committed in a git repository (possibly a
+// local bare one), keeping all its versions
+
+import (
+	"gate/core"
+	"gate/core/errors"
+	"gate/core/exec"
+	"gate/server"
+)
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type git struct {
+	remote
+	// the local clone of the repository
+	clone string
+}
+
+var _ HistoryRemote = &git{}
+
+var GitAllowedKeys map[string]bool = map[string]bool{
+	"repository": true,
+	"branch":     false,
+	"file":       false,
+}
+
+const (
+	git_default_branch = "master"
+	git_default_file   = "vault"
+)
+
+func newGit(name string, srv server.Server, config core.Config, remoter Remoter) (Remote, error) {
+	xdg, err := config.Xdg()
+	if err != nil {
+		return nil, err
+	}
+	cache_home, err := xdg.CacheHome()
+	if err != nil {
+		return nil, err
+	}
+	result := &git{
+		remote{
+			properties{
+				allowed:    GitAllowedKeys,
+				properties: make(map[string]string),
+			},
+			srv,
+			remoter,
+			name,
+			nil,
+			"",
+			"",
+		},
+		filepath.Join(cache_home, "remotes", name),
+	}
+	rcfile := name + ".rc"
+	for key, mandatory := range GitAllowedKeys {
+		value, err := config.Eval(rcfile, "remote", key, os.Getenv)
+		if err != nil && mandatory {
+			return nil, err
+		}
+		if value != "" {
+			result.properties.setProperty(key, value)
+		}
+	}
+	proxy, err := newProxy(name, srv, config)
+	if err != nil {
+		return nil, err
+	}
+	result.proxy = proxy
+	return result, nil
+}
+
+func (self *git) Name() string {
+	return self.name
+}
+
+func (self *git) branch() string {
+	result := self.getProperty("branch")
+	if result == "" {
+		result = git_default_branch
+	}
+	return result
+}
+
+func (self *git) file() string {
+	result := self.getProperty("file")
+	if result == "" {
+		result = git_default_file
+	}
+	return result
+}
+
+// Run git in the given directory; returns its raw output
+func (self *git) run(dir string, args ...string) (result []byte, err error) {
+	var stdout, stderr bytes.Buffer
+	prepare := func(cmd *exec.Cmd) (err error) {
+		if self.proxy != nil {
+			err = self.proxy.Install(cmd)
+			if err != nil {
+				return
+			}
+		}
+		cmd.Dir = dir
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		return
+	}
+	err = exec.Command(prepare, nil, "git", args...)
+	if err != nil {
+		return nil, gitError(args[0], stderr.String(), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// The error of a failed git command: what git said, or else the plain
+// error (without its stack trace)
+func gitError(command, stderr string, err error) error {
+	message := strings.TrimSpace(stderr)
+	if message == "" {
+		message = err.Error()
+		if e, ok := err.(errors.StackError); ok {
+			message = e.Nested.Error()
+		}
+	}
+	return errors.Newf("git %s: %s", command, message)
+}
+
+// Run git in the clone; returns its output, trimmed
+func (self *git) git(args ...string) (result string, err error) {
+	out, err := self.run(self.clone, args...)
+	if err != nil {
+		return
+	}
+	result = strings.TrimSpace(string(out))
+	return
+}
+
+// Update the clone (cloning the repository if needed), and check out
+// the branch. Returns the branch head, empty if nothing was pushed yet.
+func (self *git) fetch() (head string, err error) {
+	repository := self.getProperty("repository")
+	if repository == "" {
+		return "", errors.Newf("missing remote repository")
+	}
+	_, err = os.Stat(filepath.Join(self.clone, ".git"))
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(self.clone), 0700)
+		if err != nil {
+			return "", errors.Decorated(err)
+		}
+		_, err = self.run(filepath.Dir(self.clone), "clone", "-q", "--no-checkout", repository, self.clone)
+		if err != nil {
+			return
+		}
+		err = self.setIdentity()
+	} else if err == nil {
+		_, err = self.git("fetch", "-q", "origin")
+	} else {
+		err = errors.Decorated(err)
+	}
+	if err != nil {
+		return
+	}
+
+	branch := self.branch()
+	head, err = self.git("rev-parse", "-q", "--verify", "refs/remotes/origin/"+branch)
+	if err != nil {
+		// nothing pushed yet: the first commit will create the branch
+		_, err = self.git("symbolic-ref", "HEAD", "refs/heads/"+branch)
+		return "", err
+	}
+	// the clone is only a cache: local changes are discarded
+	_, err = self.git("checkout", "-q", "-f", "-B", branch, "origin/"+branch)
+	return
+}
+
+// Commits need an identity; use a default one if the user did not
+// configure it
+func (self *git) setIdentity() (err error) {
+	host, _ := os.Hostname()
+	for key, value := range map[string]string{"user.name": "gate", "user.email": "gate@" + host} {
+		_, e := self.git("config", key)
+		if e != nil {
+			_, err = self.git("config", key, value)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (self *git) LoadVault(file string) (err error) {
+	head, err := self.fetch()
+	if err != nil {
+		return
+	}
+	if head == "" {
+		return vaultNotFound(self.name)
+	}
+	err = copyFile(filepath.Join(self.clone, self.file()), file)
+	if err != nil {
+		return
+	}
+	self.loaded = head
+	return
+}
+
+func (self *git) AcceptVault() {
+	self.version = self.loaded
+}
+
+func (self *git) SaveVault(file string) (err error) {
+	head, err := self.fetch()
+	if err != nil {
+		return
+	}
+	target := filepath.Join(self.clone, self.file())
+	if self.version != "" {
+		if head != self.version {
+			return changedError(self.name)
+		}
+	} else if head != "" {
+		_, err = os.Stat(target)
+		if err == nil {
+			var current string
+			current, err = fileVersion(target)
+			if err == nil {
+				err = checkSame(self.name, current, file)
+			}
+		} else if os.IsNotExist(err) {
+			// the branch does not hold the vault yet
+			err = nil
+		} else {
+			err = uncheckedError(self.name, err)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	err = copyFile(file, target)
+	if err != nil {
+		return
+	}
+	_, err = self.git("add", "--", self.file())
+	if err != nil {
+		return
+	}
+	if head != "" {
+		_, e := self.git("diff", "--cached", "--quiet")
+		if e == nil {
+			// unchanged
+			self.version = head
+			return
+		}
+	}
+
+	message, err := self.message(head)
+	if err != nil {
+		return
+	}
+	_, err = self.git("commit", "-q", "-m", message)
+	if err != nil {
+		return
+	}
+	_, err = self.git("push", "-q", "origin", "HEAD:refs/heads/"+self.branch())
+	if err != nil {
+		if strings.Contains(err.Error(), "rejected") {
+			// pushed by another machine after our fetch
+			return changedError(self.name)
+		}
+		return
+	}
+	self.version, err = self.git("rev-parse", "HEAD")
+	return
+}
+
+// The commit message: the host, and the keys changed since the
+// previous commit
+func (self *git) message(head string) (result string, err error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", errors.Decorated(err)
+	}
+	if head == "" {
+		return fmt.Sprintf("Vault created on %s", host), nil
+	}
+
+	result = fmt.Sprintf("Vault saved on %s", host)
+	changes, e := self.changes(head)
+	if e != nil {
+		// e.g. the previous vault has another master: keys unknown
+		return
+	}
+	changed := append(changes.Added, changes.Updated...)
+	sort.Strings(changed)
+	if len(changed) > 0 {
+		result = fmt.Sprintf("%s\n\nChanged keys: %s", result, strings.Join(changed, ", "))
+	}
+	if len(changes.Deleted) > 0 {
+		result = fmt.Sprintf("%s\n\nDeleted keys: %s", result, strings.Join(changes.Deleted, ", "))
+	}
+	return
+}
+
+// Ask the server what changed since the vault of the given commit
+func (self *git) changes(head string) (result server.MergeDiff, err error) {
+	previous, err := self.run(self.clone, "show", head+":"+self.file())
+	if err != nil {
+		return
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(self.clone), ".previous_vault.")
+	if err != nil {
+		return result, errors.Decorated(err)
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(previous)
+	e := temp.Close()
+	if err == nil {
+		err = e
+	}
+	if err != nil {
+		return result, errors.Decorated(err)
+	}
+	err = self.server.Changes(temp.Name(), &result)
+	return
+}
+
+func (self *git) Log() (result []Revision, err error) {
+	head, err := self.fetch()
+	if err != nil || head == "" {
+		return
+	}
+	out, err := self.git("log", "--format=%H %ct %s", head, "--", self.file())
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		seconds, e := strconv.ParseInt(fields[1], 10, 64)
+		if e != nil {
+			continue
+		}
+		result = append(result, Revision{
+			Id:      fields[0],
+			Date:    time.Unix(seconds, 0),
+			Message: fields[2],
+		})
+	}
+	return
+}
+
+func (self *git) Restore(revision string, file string) (err error) {
+	head, err := self.fetch()
+	if err != nil {
+		return
+	}
+	if strings.HasPrefix(revision, "-") {
+		return errors.Newf("Invalid revision: %s", revision)
+	}
+	id, err := self.git("rev-parse", "-q", "--verify", revision+"^{commit}")
+	if err != nil {
+		return errors.Newf("Unknown revision: %s", revision)
+	}
+	data, err := self.run(self.clone, "show", id+":"+self.file())
+	if err != nil {
+		return
+	}
+	err = writeFile(file, bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	self.loaded = head
+	return
+}
+
+func (self *git) Proxy() Proxy {
+	return self.proxy
+}
+
+func (self *git) SetProperty(key, value string) error {
+	return self.setProperty(key, value)
+}
+
+func (self *git) ResetProperty(key string) error {
+	return self.resetProperty(key)
+}
+
+func (self *git) StoreProperties(out io.Writer) (err error) {
+	_, err = out.Write([]byte("[remote]\nmethod = git\n"))
+	if err != nil {
+		return errors.Decorated(err)
+	}
+
+	err = self.storeProperties(out)
+	if err != nil {
+		return
+	}
+
+	if self.proxy != nil {
+		err = self.proxy.StoreProperties(out)
+	}
+
+	return
+}
@@ -0,0 +1,189 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+import (
+	"gate/core"
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A git remote on the shared bare repository, with its own clone
+func newTestGit(t *testing.T, ctrl *gomock.Controller, srv server.Server, dir, name, repository string) HistoryRemote {
+	xdg := core.NewMockXdgContext(ctrl)
+	xdg.EXPECT().CacheHome().Return(filepath.Join(dir, name), nil)
+
+	cfg := core.NewMockConfig(ctrl)
+	rcfile := name + ".rc"
+	cfg.EXPECT().Eval(rcfile, "remote", "method", nil).Return("git", nil)
+	cfg.EXPECT().Xdg().Return(xdg, nil)
+	cfg.EXPECT().Eval(rcfile, "remote", "repository", gomock.Any()).Return(repository, nil)
+	cfg.EXPECT().Eval(rcfile, "remote", "branch", gomock.Any()).Return("", nil)
+	cfg.EXPECT().Eval(rcfile, "remote", "file", gomock.Any()).Return("", nil)
+	cfg.EXPECT().Eval(rcfile, "proxy", "host", gomock.Any()).Return("", nil)
+
+	rem, err := NewRemoter(srv, cfg).Remote(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rem.(HistoryRemote)
+}
+
+func TestGitRemote(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repository := filepath.Join(dir, "vault.git")
+	out, err := exec.Command("git", "init", "-q", "--bare", repository).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Changes(gomock.Any(), gomock.Any()).Do(func(previous string, reply *server.MergeDiff) {
+		// compared with the committed vault, not with dates
+		checkFile(t, previous, "v1")
+		*reply = server.MergeDiff{Added: []string{"new"}, Updated: []string{"changed"}, Deleted: []string{"gone"}}
+	})
+
+	a := newTestGit(t, ctrl, srv, dir, "a", repository)
+	b := newTestGit(t, ctrl, srv, dir, "b", repository)
+	file_a := filepath.Join(dir, "vault_a")
+	file_b := filepath.Join(dir, "vault_b")
+
+	err = a.LoadVault(file_a)
+	if err == nil {
+		t.Error("expected error: no remote vault yet")
+	}
+
+	err = ioutil.WriteFile(file_a, []byte("v1"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.SaveVault(file_a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.LoadVault(file_b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.AcceptVault()
+	checkFile(t, file_b, "v1")
+
+	// saving an unchanged vault does not commit
+	err = b.SaveVault(file_b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(file_b, []byte("v2"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.SaveVault(file_b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a did not load the vault saved by b
+	err = ioutil.WriteFile(file_a, []byte("v3"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.SaveVault(file_a)
+	if err == nil {
+		t.Error("expected error: remote vault changed")
+	}
+
+	err = a.LoadVault(file_a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.AcceptVault()
+	checkFile(t, file_a, "v2")
+
+	log, err := a.Log()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 {
+		t.Fatalf("expected 2 revisions but got %d", len(log))
+	}
+	if !strings.HasPrefix(log[0].Message, "Vault saved on ") || !strings.HasPrefix(log[1].Message, "Vault created on ") {
+		t.Errorf("unexpected log: %v", log)
+	}
+
+	out, err = exec.Command("git", "--git-dir", repository, "log", "-1", "--format=%B").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if !strings.Contains(string(out), "Changed keys: changed, new\n\nDeleted keys: gone\n") {
+		t.Errorf("unexpected commit message: %s", out)
+	}
+
+	restored := filepath.Join(dir, "restored")
+	err = a.Restore(log[1].Id[:8], restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, restored, "v1")
+
+	err = a.Restore("no-such-revision", restored)
+	if err == nil {
+		t.Error("expected error: unknown revision")
+	}
+	err = a.Restore("--all", restored)
+	if err == nil {
+		t.Error("expected error: invalid revision")
+	}
+}
+
+func TestGitError(t *testing.T) {
+	err := gitError("push", "", errors.Decorated(fmt.Errorf("exit status 1")))
+	message := err.(errors.StackError).Nested.Error()
+	if message != "git push: exit status 1" {
+		t.Errorf("bad message: %s", message)
+	}
+
+	err = gitError("push", "  rejected\n", errors.Decorated(fmt.Errorf("exit status 1")))
+	message = err.(errors.StackError).Nested.Error()
+	if message != "git push: rejected" {
+		t.Errorf("bad message: %s", message)
+	}
+}
@@ -0,0 +1,372 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+// Native HTTP (and WebDAV) remote: the password never appears on a
+// command line
+
+import (
+	"gate/core"
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type http_remote remote
+
+var _ Remote = &http_remote{}
+
+var HttpAllowedKeys map[string]bool = map[string]bool{
+	"url":         true,
+	"user":        false,
+	"passkey":     false,
+	"put_request": false,
+	"get_request": false,
+	"ca_file":     false,
+}
+
+const http_timeout = 5 * time.Minute
+
+func newHttp(name string, srv server.Server, config core.Config, remoter Remoter) (Remote, error) {
+	result := &http_remote{
+		properties{
+			allowed:    HttpAllowedKeys,
+			properties: make(map[string]string),
+		},
+		srv,
+		remoter,
+		name,
+		nil,
+		"",
+		"",
+	}
+	rcfile := name + ".rc"
+	for key, mandatory := range HttpAllowedKeys {
+		value, err := config.Eval(rcfile, "remote", key, os.Getenv)
+		if err != nil && mandatory {
+			return nil, err
+		}
+		if value != "" {
+			result.properties.setProperty(key, value)
+		}
+	}
+	proxy, err := newProxy(name, srv, config)
+	if err != nil {
+		return nil, err
+	}
+	result.proxy = proxy
+	return result, nil
+}
+
+func (self *http_remote) Name() string {
+	return self.name
+}
+
+func (self *http_remote) client() (result *http.Client, err error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+	if self.proxy != nil {
+		var proxy_url *url.URL
+		proxy_url, err = self.proxy.Url()
+		if err != nil {
+			return
+		}
+		if proxy_url.Scheme == "socks5h" {
+			// the native SOCKS5 client always lets the proxy resolve names
+			proxy_url.Scheme = "socks5"
+		}
+		transport.Proxy = http.ProxyURL(proxy_url)
+	}
+	ca_file := self.getProperty("ca_file")
+	if ca_file != "" {
+		var pem []byte
+		pem, err = ioutil.ReadFile(ca_file)
+		if err != nil {
+			return nil, errors.Decorated(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Newf("No certificate found in %s", ca_file)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	result = &http.Client{
+		Transport: transport,
+		Timeout:   http_timeout,
+	}
+	return
+}
+
+// Send the request; it is sent again, with the credentials, if the
+// server asks for them. The body function is called for each request.
+func (self *http_remote) do(method string, body func() (io.ReadCloser, int64, error), header http.Header) (result *http.Response, err error) {
+	url := self.getProperty("url")
+	if url == "" {
+		return nil, errors.Newf("missing remote vault url")
+	}
+	client, err := self.client()
+	if err != nil {
+		return
+	}
+
+	request := func() (req *http.Request, err error) {
+		var (
+			in     io.ReadCloser
+			length int64
+		)
+		if body != nil {
+			in, length, err = body()
+			if err != nil {
+				return
+			}
+		}
+		req, err = http.NewRequest(method, url, in)
+		if err != nil {
+			if in != nil {
+				in.Close()
+			}
+			return nil, errors.Decorated(err)
+		}
+		req.ContentLength = length
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		return
+	}
+
+	req, err := request()
+	if err != nil {
+		return
+	}
+	result, err = client.Do(req)
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+
+	user := self.getProperty("user")
+	if result.StatusCode != http.StatusUnauthorized || user == "" {
+		return
+	}
+	challenges := result.Header[http.CanonicalHeaderKey("WWW-Authenticate")]
+	result.Body.Close()
+
+	pass, err := self.password()
+	if err != nil {
+		return
+	}
+	req, err = request()
+	if err != nil {
+		return
+	}
+	err = authorize(req, challenges, user, pass)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return
+	}
+	result, err = client.Do(req)
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	return
+}
+
+func (self *http_remote) password() (result string, err error) {
+	passkey := self.getProperty("passkey")
+	if passkey != "" {
+		err = self.server.Get(passkey, &result)
+	}
+	return
+}
+
+func httpError(method string, resp *http.Response) error {
+	return errors.Newf("%s %s: %s", method, resp.Request.URL, resp.Status)
+}
+
+// The WebDAV properties of the remote vault (PROPFIND reply)
+type webdav_multistatus struct {
+	ETags []string `xml:"response>propstat>prop>getetag"`
+}
+
+// The version of a remote vault without ETag is the hash of its
+// content, checked by loading it again before saving
+const content_version = "content:"
+
+func (self *http_remote) LoadVault(file string) (err error) {
+	etag, err := self.load(file)
+	if err != nil {
+		return
+	}
+	if etag == "" {
+		etag, err = fileVersion(file)
+		if err != nil {
+			return
+		}
+		etag = content_version + etag
+	}
+	self.loaded = etag
+	return
+}
+
+func (self *http_remote) AcceptVault() {
+	self.version = self.loaded
+}
+
+// Download the remote vault; returns its ETag, if known
+func (self *http_remote) load(file string) (etag string, err error) {
+	method := self.getProperty("get_request")
+	if method == "" {
+		method = "GET"
+	}
+	var header http.Header
+	if method == "PROPFIND" {
+		header = http.Header{"Depth": {"0"}}
+	}
+	resp, err := self.do(method, nil, header)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	etag = resp.Header.Get("ETag")
+	if resp.StatusCode == http.StatusMultiStatus {
+		// WebDAV: the properties only; the vault is then downloaded
+		var status webdav_multistatus
+		err = xml.NewDecoder(resp.Body).Decode(&status)
+		if err != nil {
+			return "", errors.Decorated(err)
+		}
+		if len(status.ETags) > 0 {
+			etag = status.ETags[0]
+		}
+		resp.Body.Close()
+		resp, err = self.do("GET", nil, nil)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		method = "GET"
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", vaultNotFound(resp.Request.URL.String())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", httpError(method, resp)
+	}
+
+	err = writeFile(file, resp.Body)
+	if err != nil {
+		return
+	}
+	if etag == "" {
+		etag = resp.Header.Get("ETag")
+	}
+	return
+}
+
+func (self *http_remote) SaveVault(file string) (err error) {
+	method := self.getProperty("put_request")
+	if method == "" {
+		method = "PUT"
+	}
+	body := func() (io.ReadCloser, int64, error) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, 0, errors.Decorated(err)
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+	}
+	header := http.Header{}
+	if self.version == "" || strings.HasPrefix(self.version, content_version) {
+		load := func(file string) (err error) {
+			_, err = self.load(file)
+			return
+		}
+		err = checkVersion(self.name, strings.TrimPrefix(self.version, content_version), load, file)
+		if err != nil {
+			return
+		}
+	} else {
+		// only replace the version that was loaded
+		header.Set("If-Match", self.version)
+	}
+	resp, err := self.do(method, body, header)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		self.version = resp.Header.Get("ETag")
+		if self.version == "" {
+			version, e := fileVersion(file)
+			if e == nil {
+				self.version = content_version + version
+			}
+		}
+	case http.StatusPreconditionFailed:
+		err = changedError(self.name)
+	default:
+		err = httpError(method, resp)
+	}
+	return
+}
+
+func (self *http_remote) Proxy() Proxy {
+	return self.proxy
+}
+
+func (self *http_remote) SetProperty(key, value string) error {
+	return self.setProperty(key, value)
+}
+
+func (self *http_remote) ResetProperty(key string) error {
+	return self.resetProperty(key)
+}
+
+func (self *http_remote) StoreProperties(out io.Writer) (err error) {
+	_, err = out.Write([]byte("[remote]\nmethod = http\n"))
+	if err != nil {
+		return errors.Decorated(err)
+	}
+
+	err = self.storeProperties(out)
+	if err != nil {
+		return
+	}
+
+	if self.proxy != nil {
+		err = self.proxy.StoreProperties(out)
+	}
+
+	return
+}
@@ -0,0 +1,441 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+import (
+	"gate/server"
+)
+
+import (
+	"crypto/md5"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// A remote vault served over http, with its ETag
+type testVault struct {
+	mutex   sync.Mutex
+	content string
+	version int
+	noETag  bool
+}
+
+func (self *testVault) etag() string {
+	if self.noETag {
+		return ""
+	}
+	return fmt.Sprintf(`"v%d"`, self.version)
+}
+
+func (self *testVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	switch r.Method {
+	case "GET":
+		if !self.noETag {
+			w.Header().Set("ETag", self.etag())
+		}
+		fmt.Fprint(w, self.content)
+	case "PROPFIND":
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>/vault</d:href><d:propstat>
+<d:prop><d:getetag>%s</d:getetag></d:prop><d:status>HTTP/1.1 200 OK</d:status>
+</d:propstat></d:response></d:multistatus>`, self.etag())
+	case "PUT":
+		match := r.Header.Get("If-Match")
+		if match != "" && match != self.etag() {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		self.content = string(data)
+		self.version++
+		if !self.noETag {
+			w.Header().Set("ETag", self.etag())
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (self *testVault) get() (string, int) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.content, self.version
+}
+
+func (self *testVault) change(content string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.content = content
+	self.version++
+}
+
+func basicAuth(handler http.Handler, user, pass string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != user || p != pass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gate"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func digestAuth(handler http.Handler, user, pass string) http.Handler {
+	const realm = "gate"
+	const nonce = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+	md5hex := func(data string) string {
+		return fmt.Sprintf("%x", md5.Sum([]byte(data)))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, params := parseChallenge(r.Header.Get("Authorization"))
+		ha1 := md5hex(user + ":" + realm + ":" + pass)
+		ha2 := md5hex(r.Method + ":" + params["uri"])
+		expected := md5hex(ha1 + ":" + nonce + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+		if scheme != "Digest" || params["username"] != user || params["uri"] != r.URL.RequestURI() ||
+			params["qop"] != "auth" || params["opaque"] != "xyz" || params["response"] != expected {
+			w.Header().Add("WWW-Authenticate", `Basic realm="gate"`)
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth,auth-int", nonce="%s", opaque="xyz"`, realm, nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func newTestHttp(t *testing.T, srv server.Server, props map[string]string) (result *http_remote, dir string) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = &http_remote{
+		properties: properties{
+			allowed:    HttpAllowedKeys,
+			properties: props,
+		},
+		server: srv,
+		name:   "web",
+	}
+	return
+}
+
+func checkFile(t *testing.T, file, expected string) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("bad content of %s: '%s' instead of '%s'", file, data, expected)
+	}
+}
+
+func TestHttpRemoteBasic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	vault := &testVault{content: "remote", version: 1}
+	web := httptest.NewServer(basicAuth(vault, "me", "p@ss:word"))
+	defer web.Close()
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Get("web_key", gomock.Any()).Do(func(_ string, reply *string) {
+		*reply = "p@ss:word"
+	}).AnyTimes()
+
+	rem, dir := newTestHttp(t, srv, map[string]string{"url": web.URL + "/vault", "user": "me", "passkey": "web_key"})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "vault")
+
+	err := rem.LoadVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem.AcceptVault()
+	checkFile(t, file, "remote")
+	if rem.version != `"v1"` {
+		t.Errorf("bad version: %s", rem.version)
+	}
+
+	err = ioutil.WriteFile(file, []byte("merged"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rem.SaveVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := vault.get()
+	if content != "merged" || rem.version != `"v2"` {
+		t.Errorf("bad saved vault: '%s' (%s)", content, rem.version)
+	}
+
+	// another machine saved in between: refused
+	vault.change("other")
+	err = rem.SaveVault(file)
+	if err == nil {
+		t.Error("expected error")
+	}
+	content, _ = vault.get()
+	if content != "other" {
+		t.Errorf("remote vault overwritten: '%s'", content)
+	}
+}
+
+func TestHttpRemoteBadPassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	vault := &testVault{content: "remote", version: 1}
+	web := httptest.NewServer(basicAuth(vault, "me", "secret"))
+	defer web.Close()
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Get("web_key", gomock.Any()).Do(func(_ string, reply *string) {
+		*reply = "wrong"
+	})
+
+	rem, dir := newTestHttp(t, srv, map[string]string{"url": web.URL + "/vault", "user": "me", "passkey": "web_key"})
+	defer os.RemoveAll(dir)
+
+	err := rem.LoadVault(filepath.Join(dir, "vault"))
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestHttpRemoteDigest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	vault := &testVault{content: "remote", version: 1}
+	web := httptest.NewServer(digestAuth(vault, "me", "secret"))
+	defer web.Close()
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Get("web_key", gomock.Any()).Do(func(_ string, reply *string) {
+		*reply = "secret"
+	}).Times(2)
+
+	rem, dir := newTestHttp(t, srv, map[string]string{"url": web.URL + "/dav/vault?x=1", "user": "me", "passkey": "web_key"})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "vault")
+
+	err := rem.LoadVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem.AcceptVault()
+	checkFile(t, file, "remote")
+
+	err = rem.SaveVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, version := vault.get()
+	if version != 2 {
+		t.Errorf("vault not saved: %d", version)
+	}
+}
+
+func TestHttpRemoteWebdav(t *testing.T) {
+	vault := &testVault{content: "remote", version: 3}
+	web := httptest.NewServer(vault)
+	defer web.Close()
+
+	rem, dir := newTestHttp(t, nil, map[string]string{"url": web.URL + "/vault", "get_request": "PROPFIND", "put_request": "PUT"})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "vault")
+
+	err := rem.LoadVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem.AcceptVault()
+	checkFile(t, file, "remote")
+	if rem.version != `"v3"` {
+		t.Errorf("bad version: %s", rem.version)
+	}
+}
+
+func TestHttpRemoteCertificate(t *testing.T) {
+	vault := &testVault{content: "remote", version: 1}
+	web := httptest.NewTLSServer(vault)
+	defer web.Close()
+
+	rem, dir := newTestHttp(t, nil, map[string]string{"url": web.URL + "/vault"})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "vault")
+
+	// unknown authority
+	err := rem.LoadVault(file)
+	if err == nil {
+		t.Error("expected error")
+	}
+
+	ca_file := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(ca_file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: web.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem.setProperty("ca_file", ca_file)
+	err = rem.LoadVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, file, "remote")
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Digest realm="a \"b\", c", nonce=xyz,qop="auth"`)
+	if scheme != "Digest" || params["realm"] != `a "b", c` || params["nonce"] != "xyz" || params["qop"] != "auth" {
+		t.Errorf("bad challenge: %s %v", scheme, params)
+	}
+}
+
+func TestHttpRemoteNotMerged(t *testing.T) {
+	vault := &testVault{content: "remote", version: 1}
+	web := httptest.NewServer(vault)
+	defer web.Close()
+
+	rem, dir := newTestHttp(t, nil, map[string]string{"url": web.URL + "/vault"})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "vault")
+
+	// loaded but not merged (e.g. merge --dry-run)
+	err := rem.LoadVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file, []byte("local"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rem.SaveVault(file)
+	if err == nil {
+		t.Error("expected error")
+	}
+	content, _ := vault.get()
+	if content != "remote" {
+		t.Errorf("remote vault overwritten: '%s'", content)
+	}
+}
+
+func TestHttpRemoteWithoutETag(t *testing.T) {
+	vault := &testVault{content: "remote", version: 1, noETag: true}
+	web := httptest.NewServer(vault)
+	defer web.Close()
+
+	rem, dir := newTestHttp(t, nil, map[string]string{"url": web.URL + "/vault"})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "vault")
+
+	err := rem.LoadVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rem.AcceptVault()
+	err = ioutil.WriteFile(file, []byte("merged"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rem.SaveVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := vault.get()
+	if content != "merged" {
+		t.Errorf("bad saved vault: '%s'", content)
+	}
+
+	// the saved version is known too
+	err = rem.SaveVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vault.change("other")
+	err = rem.SaveVault(file)
+	if err == nil {
+		t.Error("expected error")
+	}
+	content, _ = vault.get()
+	if content != "other" {
+		t.Errorf("remote vault overwritten: '%s'", content)
+	}
+}
+
+func TestHttpRemoteLoadFailure(t *testing.T) {
+	vault := &testVault{content: "remote", version: 1}
+	status := http.StatusServiceUnavailable
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vault.mutex.Lock()
+		failure := status
+		vault.mutex.Unlock()
+		if r.Method == "GET" && failure != http.StatusOK {
+			w.WriteHeader(failure)
+			return
+		}
+		vault.ServeHTTP(w, r)
+	}))
+	defer web.Close()
+
+	rem, dir := newTestHttp(t, nil, map[string]string{"url": web.URL + "/vault"})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "vault")
+	err := ioutil.WriteFile(file, []byte("local"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// never loaded, and the remote vault cannot be checked
+	err = rem.SaveVault(file)
+	if err == nil {
+		t.Error("expected error")
+	}
+	content, _ := vault.get()
+	if content != "remote" {
+		t.Errorf("remote vault overwritten: '%s'", content)
+	}
+
+	// never loaded, and there is no remote vault yet
+	vault.mutex.Lock()
+	status = http.StatusNotFound
+	vault.mutex.Unlock()
+	err = rem.SaveVault(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ = vault.get()
+	if content != "local" {
+		t.Errorf("bad saved vault: '%s'", content)
+	}
+}
@@ -18,18 +18,24 @@ package remote
 // Remote proxy
 
 import (
+	"gate/core"
 	"gate/core/errors"
 	"gate/core/exec"
+	"gate/server"
 )
 
 import (
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 )
 
 type proxy struct {
 	properties
+	server server.Server
 }
 
 var _ Proxy = &proxy{}
@@ -42,40 +48,190 @@ var ProxyAllowedKeys map[string]bool = map[string]bool{
 	"pass":     false,
 }
 
-func newProxy() Proxy {
-	return &proxy{
+// The supported proxy protocols
+var proxyProtocols map[string]bool = map[string]bool{
+	"http":    true,
+	"https":   true,
+	"socks5":  true,
+	"socks5h": true,
+}
+
+// The environment variables an external command may read its proxy
+// from; replaced by ALL_PROXY when a proxy is installed
+var proxyVariables []string = []string{
+	"ALL_PROXY", "all_proxy",
+	"HTTP_PROXY", "http_proxy",
+	"HTTPS_PROXY", "https_proxy",
+}
+
+// Read the [proxy] section of the remote's configuration file; the
+// result is nil if no proxy host is set
+func newProxy(name string, srv server.Server, config core.Config) (Proxy, error) {
+	rcfile := name + ".rc"
+	host, err := config.Eval(rcfile, "proxy", "host", os.Getenv)
+	if err != nil || host == "" {
+		return nil, nil
+	}
+	result := &proxy{
 		properties{
 			allowed:    ProxyAllowedKeys,
 			properties: make(map[string]string),
 		},
+		srv,
+	}
+	result.setProperty("host", host)
+	for key := range ProxyAllowedKeys {
+		if key != "host" {
+			value, err := config.Eval(rcfile, "proxy", key, os.Getenv)
+			if err == nil && value != "" {
+				result.setProperty(key, value)
+			}
+		}
 	}
+	_, err = result.protocol()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func (self *proxy) Install(cmd *exec.Cmd) (err error) {
-	var (
-		url string
-	)
+func (self *proxy) protocol() (result string, err error) {
+	result = self.getProperty("protocol")
+	if result == "" {
+		result = "http"
+	}
+	if !proxyProtocols[result] {
+		err = errors.Newf("unsupported proxy protocol: %s", result)
+	}
+	return
+}
+
+func (self *proxy) address() string {
 	host := self.getProperty("host")
+	port := self.getProperty("port")
+	if port != "" {
+		host = fmt.Sprintf("%s:%s", host, port)
+	}
+	return host
+}
+
+// The password is a key of the vault
+func (self *proxy) password() (result string, err error) {
+	passkey := self.getProperty("pass")
+	if passkey != "" {
+		err = self.server.Get(passkey, &result)
+	}
+	return
+}
+
+func (self *proxy) Url() (result *url.URL, err error) {
+	protocol, err := self.protocol()
+	if err != nil {
+		return
+	}
+	result = &url.URL{
+		Scheme: protocol,
+		Host:   self.address(),
+	}
 	user := self.getProperty("user")
-	if user == "" {
-		url = host
-	} else {
-		pass := self.getProperty("pass")
+	if user != "" {
+		var pass string
+		pass, err = self.password()
+		if err != nil {
+			return nil, err
+		}
 		if pass == "" {
-			url = fmt.Sprintf("%s@%s", user, host)
+			result.User = url.User(user)
 		} else {
-			url = fmt.Sprintf("%s:%s@%s", user, escape_pass_url(pass), host)
+			result.User = url.UserPassword(user, pass)
 		}
 	}
+	return
+}
+
+func (self *proxy) Install(cmd *exec.Cmd) (err error) {
+	proxy_url, err := self.Url()
+	if err != nil {
+		return
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(withoutProxy(env), fmt.Sprintf("ALL_PROXY=%s", proxy_url))
+	return
+}
+
+func withoutProxy(env []string) (result []string) {
+	result = make([]string, 0, len(env)+1)
+	for _, variable := range env {
+		keep := true
+		for _, name := range proxyVariables {
+			if strings.HasPrefix(variable, name+"=") {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, variable)
+		}
+	}
+	return
+}
+
+// The ProxyCommand is run by a shell: only plain names, that are not
+// options either
+func validProxyName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._-", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ssh does not read ALL_PROXY: it goes through the proxy using the
+// OpenBSD netcat. The password cannot be given to nc.
+func (self *proxy) SshOptions() (result []string, err error) {
+	protocol, err := self.protocol()
+	if err != nil {
+		return
+	}
+	host := self.getProperty("host")
+	if !validProxyName(host) {
+		return nil, errors.Newf("invalid proxy host: %s", host)
+	}
 	port := self.getProperty("port")
 	if port != "" {
-		url = fmt.Sprintf("%s:%s", url, port)
+		n, e := strconv.Atoi(port)
+		if e != nil || n < 1 || n > 65535 || !validProxyName(port) {
+			return nil, errors.Newf("invalid proxy port: %s", port)
+		}
+	}
+	var command string
+	switch protocol {
+	case "http":
+		command = "nc -X connect"
+	case "socks5", "socks5h":
+		command = "nc -X 5"
+	default:
+		return nil, errors.Newf("unsupported ssh proxy protocol: %s", protocol)
 	}
-	protocol := self.getProperty("protocol")
-	if protocol != "" {
-		url = fmt.Sprintf("%s://%s", protocol, url)
+	user := self.getProperty("user")
+	if user != "" {
+		if !validProxyName(user) {
+			return nil, errors.Newf("invalid proxy user: %s", user)
+		}
+		if self.getProperty("pass") != "" {
+			return nil, errors.Newf("ssh proxy password not supported")
+		}
+		command = fmt.Sprintf("%s -P %s", command, user)
 	}
-	cmd.Env = append(os.Environ(), fmt.Sprintf("ALL_PROXY=%s", url))
+	command = fmt.Sprintf("%s -x %s %%h %%p", command, self.address())
+	result = []string{"-o", "ProxyCommand=" + command}
 	return
 }
 
@@ -23,7 +23,14 @@ import (
 )
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
 )
 
 type Remoter interface {
@@ -41,7 +48,11 @@ var _ Remoter = &remoter{}
 type Remote interface {
 	Name() string
 
+	// Download the remote vault; its version is only remembered
 	LoadVault(file string) error
+	// The last loaded vault was merged or loaded: SaveVault may replace
+	// that version (and only that one)
+	AcceptVault()
 	SaveVault(file string) error
 
 	Proxy() Proxy
@@ -51,16 +62,43 @@ type Remote interface {
 	StoreProperties(io.Writer) error
 }
 
+// A remote that keeps the past versions of the vault
+type HistoryRemote interface {
+	Remote
+
+	// The versions of the vault, most recent first
+	Log() ([]Revision, error)
+	// Copy the vault as it was at the given revision into the file; the
+	// current version is then the loaded one (see AcceptVault)
+	Restore(revision string, file string) error
+}
+
+type Revision struct {
+	Id      string
+	Date    time.Time
+	Message string
+}
+
 type remote struct {
 	properties
 	server  server.Server
 	remoter Remoter
 	name    string
 	proxy   Proxy
+	// the version of the remote vault when it was merged or loaded
+	// (e.g. its ETag)
+	version string
+	// the version of the last downloaded remote vault, not accepted yet
+	loaded string
 }
 
 type Proxy interface {
+	// Set the proxy of an external command (ALL_PROXY)
 	Install(cmd *exec.Cmd) error
+	// The proxy URL, for the native transports
+	Url() (*url.URL, error)
+	// The ssh options to go through the proxy
+	SshOptions() ([]string, error)
 
 	SetProperty(key, value string) error
 	ResetProperty(key string) error
@@ -101,6 +139,14 @@ func (self *remoter) readRemote(name string) (result Remote, err error) {
 	switch method {
 	case "curl":
 		result, err = newCurl(name, self.server, self.config, self)
+	case "http":
+		result, err = newHttp(name, self.server, self.config, self)
+	case "scp":
+		result, err = newScp(name, self.server, self.config, self)
+	case "file":
+		result, err = newFile(name, self.server, self.config, self)
+	case "git":
+		result, err = newGit(name, self.server, self.config, self)
 	case "":
 		err = errors.Newf("Unknown remote: %s", name)
 	default:
@@ -109,6 +155,107 @@ func (self *remoter) readRemote(name string) (result Remote, err error) {
 	return
 }
 
+// The error returned by SaveVault when the remote vault was changed
+// (e.g. by another machine) since it was loaded
+func changedError(name string) error {
+	return errors.Newf("The remote vault %s was changed since it was loaded: merge again", name)
+}
+
+func notMergedError(name string) error {
+	return errors.Newf("The remote vault %s was not merged: merge it first", name)
+}
+
+// The error returned by SaveVault when the remote vault could not be
+// loaded to check its version
+func uncheckedError(name string, err error) error {
+	if e, ok := err.(errors.StackError); ok {
+		err = e.Nested
+	}
+	return errors.Newf("The remote vault %s could not be checked (%s): merge again", name, err)
+}
+
+// The nested error of a load when the remote vault does not exist
+type notFoundError string
+
+func (self notFoundError) Error() string {
+	return string(self)
+}
+
+func vaultNotFound(where string) error {
+	return errors.Decorated(notFoundError(fmt.Sprintf("remote vault not found: %s", where)))
+}
+
+func isNotFound(err error) bool {
+	if e, ok := err.(errors.StackError); ok {
+		err = e.Nested
+	}
+	_, ok := err.(notFoundError)
+	return ok
+}
+
+// The version of a vault file: the hash of its content
+func fileVersion(file string) (result string, err error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return "", errors.Decorated(err)
+	}
+	defer in.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, in)
+	if err != nil {
+		return "", errors.Decorated(err)
+	}
+	result = fmt.Sprintf("%x", h.Sum(nil))
+	return
+}
+
+// For the methods that cannot ask for the version of the remote vault:
+// load it again (next to the file), and check that it is still the
+// accepted version.
+// If no version was accepted (the vault was neither merged nor loaded),
+// the remote vault must either not exist yet, or be the same as the
+// file. Any other load failure prevents the save.
+func checkVersion(name, version string, load func(file string) error, file string) (err error) {
+	check, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	check.Close()
+	defer os.Remove(check.Name())
+
+	err = load(check.Name())
+	if err != nil {
+		if version == "" && isNotFound(err) {
+			// no remote vault yet
+			return nil
+		}
+		return uncheckedError(name, err)
+	}
+	current, err := fileVersion(check.Name())
+	if err != nil {
+		return
+	}
+	if version == "" {
+		return checkSame(name, current, file)
+	}
+	if current != version {
+		return changedError(name)
+	}
+	return
+}
+
+// A remote vault that was never merged may only be replaced by itself
+func checkSame(name, current, file string) (err error) {
+	local, err := fileVersion(file)
+	if err != nil {
+		return
+	}
+	if current != local {
+		return notMergedError(name)
+	}
+	return
+}
+
 func escape_pass_url(data string) string {
 	buffer := make([]byte, 0, 3*len(data))
 	for _, b := range []byte(data) {
@@ -0,0 +1,97 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "vault")
+	err = ioutil.WriteFile(file, []byte("loaded"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := fileVersion(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := "loaded"
+	load := func(file string) error {
+		return ioutil.WriteFile(file, []byte(remote), 0600)
+	}
+
+	err = checkVersion("foo", version, load, file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	remote = "changed"
+	err = checkVersion("foo", version, load, file)
+	if err == nil {
+		t.Error("expected error")
+	}
+
+	// never merged: the remote vault may only be missing or the same
+	err = checkVersion("foo", "", load, file)
+	if err == nil {
+		t.Error("expected error")
+	}
+	remote = "loaded"
+	err = checkVersion("foo", "", load, file)
+	if err != nil {
+		t.Error(err)
+	}
+	missing := func(file string) error {
+		return vaultNotFound("foo")
+	}
+	err = checkVersion("foo", "", missing, file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the remote vault may exist: a failure is not a missing vault
+	failing := func(file string) error {
+		return errors.New("connection refused")
+	}
+	err = checkVersion("foo", "", failing, file)
+	if err == nil {
+		t.Error("expected error")
+	}
+	err = checkVersion("foo", version, failing, file)
+	if err == nil {
+		t.Error("expected error")
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, ".vault.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("temporary files left: %v", files)
+	}
+}
@@ -25,6 +25,7 @@ import (
 )
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -52,6 +53,8 @@ func newScp(name string, srv server.Server, config core.Config, remoter Remoter)
 		remoter,
 		name,
 		nil,
+		"",
+		"",
 	}
 	file := name + ".rc"
 	for key, mandatory := range ScpAllowedKeys {
@@ -63,6 +66,11 @@ func newScp(name string, srv server.Server, config core.Config, remoter Remoter)
 			result.properties.setProperty(key, value)
 		}
 	}
+	proxy, err := newProxy(name, srv, config)
+	if err != nil {
+		return nil, err
+	}
+	result.proxy = proxy
 	return result, nil
 }
 
@@ -70,9 +78,15 @@ func (self *scp) Name() string {
 	return self.name
 }
 
-func (self *scp) arguments() (result []string, err error) {
+// Neither an option nor a part of another url field
+func validScpName(name string) bool {
+	return !strings.HasPrefix(name, "-") && !strings.ContainsAny(name, "@:/ \t\n")
+}
+
+// The scp options and the remote url
+func (self *scp) arguments() (options []string, url string, err error) {
 	remote_file := self.getProperty("file")
-	url := remote_file
+	url = remote_file
 	if remote_file == "" {
 		err = errors.Newf("missing remote vault file")
 		return
@@ -80,60 +94,118 @@ func (self *scp) arguments() (result []string, err error) {
 	user := self.getProperty("user")
 	host := self.getProperty("host")
 	if host != "" {
+		if !validScpName(host) {
+			err = errors.Newf("invalid host: %s", host)
+			return
+		}
 		if user == "" {
 			url = fmt.Sprintf("%s:%s", host, remote_file)
+		} else if !validScpName(user) {
+			err = errors.Newf("invalid user: %s", user)
+			return
 		} else {
 			url = fmt.Sprintf("%s@%s:%s", user, host, remote_file)
 		}
 	} else if user != "" {
 		err = errors.Newf("user without host")
 		return
+	} else if strings.HasPrefix(remote_file, "-") {
+		err = errors.Newf("invalid remote vault file: %s", remote_file)
+		return
+	}
+	options = strings.Fields(self.getProperty("options"))
+	if self.proxy != nil {
+		var proxy_options []string
+		proxy_options, err = self.proxy.SshOptions()
+		if err != nil {
+			return
+		}
+		options = append(proxy_options, options...)
+	}
+	return
+}
+
+// The scp arguments to copy from the remote vault to the file
+func (self *scp) loadArguments(file string) (result []string, err error) {
+	options, url, err := self.arguments()
+	if err != nil {
+		return
+	}
+	result = append(options, url, file)
+	return
+}
+
+// The scp arguments to copy from the file to the remote vault
+func (self *scp) saveArguments(file string) (result []string, err error) {
+	options, url, err := self.arguments()
+	if err != nil {
+		return
 	}
-	options := self.getProperty("options")
-	result = append(strings.Split(options, " "), url)
+	result = append(options, file, url)
 	return
 }
 
 func (self *scp) LoadVault(file string) (err error) {
-	args, err := self.arguments()
+	err = self.load(file)
+	if err != nil {
+		return
+	}
+	self.loaded, err = fileVersion(file)
+	return
+}
+
+func (self *scp) AcceptVault() {
+	self.version = self.loaded
+}
+
+func (self *scp) load(file string) (err error) {
+	args, err := self.loadArguments(file)
 	if err != nil {
 		return
 	}
 
+	// what scp says tells whether the remote vault does not exist
+	var stderr bytes.Buffer
 	prepare := func(cmd *exec.Cmd) (err error) {
-		if self.proxy != nil {
-			self.proxy.Install(cmd)
-		}
 		cmd.Env = append(os.Environ(), "SSH_ASKPASS=true")
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 		return
 	}
 
-	err = exec.Command(prepare, nil, "scp", append(args, file)...)
+	err = exec.Command(prepare, nil, "scp", args...)
+	if err != nil && strings.Contains(stderr.String(), "No such file or directory") {
+		err = vaultNotFound(self.getProperty("file"))
+	}
 
 	return
 }
 
 func (self *scp) SaveVault(file string) (err error) {
-	args, err := self.arguments()
+	err = checkVersion(self.name, self.version, self.load, file)
+	if err != nil {
+		return
+	}
+
+	args, err := self.saveArguments(file)
 	if err != nil {
 		return
 	}
 
 	prepare := func(cmd *exec.Cmd) (err error) {
-		if self.proxy != nil {
-			self.proxy.Install(cmd)
-		}
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		return
 	}
 
-	err = exec.Command(prepare, nil, "scp", append([]string{file}, args...)...)
+	err = exec.Command(prepare, nil, "scp", args...)
+	if err != nil {
+		return
+	}
 
+	self.version, err = fileVersion(file)
 	return
 }
 
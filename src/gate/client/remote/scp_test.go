@@ -0,0 +1,138 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package remote
+
+import (
+	"gate/core"
+	"gate/core/errors"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"reflect"
+	"testing"
+)
+
+func expectScpConfig(cfg *core.MockConfig, name string, values map[string]string) {
+	cfg.EXPECT().Eval(name+".rc", "remote", "method", nil).Return("scp", nil)
+	for key := range ScpAllowedKeys {
+		value, ok := values[key]
+		if ok {
+			cfg.EXPECT().Eval(name+".rc", "remote", key, gomock.Any()).Return(value, nil)
+		} else {
+			cfg.EXPECT().Eval(name+".rc", "remote", key, gomock.Any()).Return("", errors.New("not set"))
+		}
+	}
+	cfg.EXPECT().Eval(name+".rc", "proxy", "host", gomock.Any()).Return("", errors.New("not set"))
+}
+
+func TestScpRemote(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	expectScpConfig(cfg, "foo", map[string]string{
+		"host":    "example.com",
+		"user":    "me",
+		"file":    "gate/vault",
+		"options": "  -P 2222   -q ",
+	})
+
+	rem, err := NewRemoter(nil, cfg).Remote("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scp, ok := rem.(*scp)
+	if !ok {
+		t.Fatalf("not an scp remote: %v", rem)
+	}
+
+	args, err := scp.loadArguments("local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-P", "2222", "-q", "me@example.com:gate/vault", "local"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("bad load arguments: %v", args)
+	}
+
+	args, err = scp.saveArguments("local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"-P", "2222", "-q", "local", "me@example.com:gate/vault"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("bad save arguments: %v", args)
+	}
+}
+
+func TestScpArguments(t *testing.T) {
+	for _, test := range []struct {
+		properties map[string]string
+		expected   []string
+	}{
+		{map[string]string{"host": "example.com", "file": "vault"}, []string{"example.com:vault", "local"}},
+		{map[string]string{"file": "/backup/vault"}, []string{"/backup/vault", "local"}},
+		{map[string]string{"host": "example.com", "file": "vault", "options": ""}, []string{"example.com:vault", "local"}},
+	} {
+		scp := &scp{properties: properties{allowed: ScpAllowedKeys, properties: test.properties}}
+		args, err := scp.loadArguments("local")
+		if err != nil {
+			t.Errorf("%v: %s", test.properties, err)
+		} else if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%v: bad arguments: %v", test.properties, args)
+		}
+	}
+}
+
+func TestScpProxyArguments(t *testing.T) {
+	proxy := &proxy{properties: properties{allowed: ProxyAllowedKeys, properties: map[string]string{
+		"host":     "proxy.example.com",
+		"port":     "1080",
+		"protocol": "socks5",
+	}}}
+	scp := &scp{properties: properties{allowed: ScpAllowedKeys, properties: map[string]string{
+		"host":    "example.com",
+		"file":    "vault",
+		"options": "-q",
+	}}, proxy: proxy}
+	args, err := scp.loadArguments("local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-o", "ProxyCommand=nc -X 5 -x proxy.example.com:1080 %h %p", "-q", "example.com:vault", "local"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("bad arguments: %v", args)
+	}
+}
+
+func TestScpInvalidArguments(t *testing.T) {
+	for _, props := range []map[string]string{
+		{"host": "example.com"},
+		{"user": "me", "file": "vault"},
+		{"host": "-oProxyCommand=evil", "file": "vault"},
+		{"host": "example.com", "user": "-F", "file": "vault"},
+		{"host": "example.com", "user": "me@other", "file": "vault"},
+		{"host": "host:22", "file": "vault"},
+		{"file": "-r"},
+	} {
+		scp := &scp{properties: properties{allowed: ScpAllowedKeys, properties: props}}
+		_, err := scp.loadArguments("local")
+		if err == nil {
+			t.Errorf("%v: expected error", props)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"gate/core/errors"
+)
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Ask the user to choose one of the given choices; any unambiguous
+// prefix is accepted. An empty answer cancels the choice.
+func (self *interaction) Choose(text string, choices []string) (result string, err error) {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("%s [%s] ", text, strings.Join(choices, "/"))
+		line, e := in.ReadString('\n')
+		if e != nil {
+			err = errors.Decorated(e)
+			return
+		}
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			err = errors.New("Cancelled")
+			return
+		}
+		candidates := make([]string, 0, len(choices))
+		for _, choice := range choices {
+			if choice == answer {
+				return choice, nil
+			}
+			if strings.HasPrefix(choice, answer) {
+				candidates = append(candidates, choice)
+			}
+		}
+		if len(candidates) == 1 {
+			return candidates[0], nil
+		}
+		fmt.Printf("Please answer one of: %s\n", strings.Join(choices, ", "))
+	}
+}
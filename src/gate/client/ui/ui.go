@@ -25,6 +25,7 @@ type UserInteraction interface {
 	XclipPassword(name string) error
 	ReadPassword(text string) (string, error)
 	Pager(text string) error
+	Choose(text string, choices []string) (string, error)
 }
 
 type interaction struct {
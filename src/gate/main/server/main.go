@@ -17,24 +17,72 @@ package main
 
 import (
 	"gate/core"
+	"gate/server/daemon"
 	server "gate/server/impl"
 )
 
 import (
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 	cfg, err := core.NewConfig()
 	if err != nil {
+		daemon.NotifyReady(err)
 		log.Fatalln(err)
 	}
+	pid, err := daemon.LockPid(cfg)
+	if err != nil {
+		daemon.NotifyReady(err)
+		log.Fatalln(err)
+	}
+	logfile, err := daemon.OpenLog(cfg)
+	if err != nil {
+		pid.Release()
+		daemon.NotifyReady(err)
+		log.Fatalln(err)
+	}
+	log.SetOutput(logfile)
 	srv, err := server.Start(cfg)
 	if err != nil {
+		pid.Release()
+		daemon.NotifyReady(err)
 		log.Fatalln(err)
 	}
+	daemon.NotifyReady(nil)
+
+	// lock the vault on demand (e.g. from screen lock or suspend hooks)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			var locked bool
+			err := srv.Server().Lock(sig.String(), &locked)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+
+	// stop cleanly (the vault is saved)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		for sig := range stop {
+			log.Printf("Stopping on %s", sig)
+			var stopped bool
+			err := srv.Server().Stop(0, &stopped)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+
 	status, err := srv.Wait()
+	pid.Release()
 	if err != nil {
 		log.Fatalln(err)
 	}
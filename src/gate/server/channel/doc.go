@@ -14,5 +14,5 @@
 // along with Gate.  If not, see <http://www.gnu.org/licenses/>.
 
 // This package contains all the implementations of the client-server communication channels.
-// Currently: only go's native RPC over HTTP.
+// Currently: go's native RPC over HTTP (on TCP or on a unix socket), and 0mq.
 package channel
@@ -24,43 +24,47 @@ import (
 )
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"net/rpc"
 	"os"
-	"sync"
 	"time"
 )
 
-type blockingHandler struct {
-	lock *sync.RWMutex
-}
-
-func (self *blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	self.lock.RLock()
-	defer self.lock.RUnlock()
-	http.DefaultServeMux.ServeHTTP(w, r)
-}
+// How long the shutdown waits for the requests in flight
+const shutdown_delay = 5 * time.Second
 
 type httpChannelServer struct {
-	config	 core.Config
-	server	 server.Server
-	handler	 *blockingHandler
+	config   core.Config
+	server   server.Server
+	http     *http.Server
+	tracker  *rpcTracker
 	listener net.Listener
+	listen   func() (net.Listener, error)
+	session  string
+	rpc      *rpc.Server
+	ping     *rpc.Server
 }
 
 type httpChannelClient struct {
-	config	 core.Config
-	proxy  server.Server
+	config    core.Config
+	proxy     server.Server
 	startFunc server.ProxyStartFunc
-	client *rpc.Client
+	client    *rpc.Client
+	dial      func() (*rpc.Client, error)
 }
 
 var _ server.Server = &httpChannelServer{}
 var _ ChannelServer = &httpChannelServer{}
 var _ ChannelClient = &httpChannelClient{}
 
+func init() {
+	RegisterTransport("http", HttpChannelServer, HttpChannelClient)
+}
+
 func networkConfig(config core.Config) (host, port string) {
 	var e error
 	host, e = config.Eval("", "connection", "host", os.Getenv)
@@ -77,34 +81,77 @@ func networkConfig(config core.Config) (host, port string) {
 // ----------------------------------------------------------------
 
 func HttpChannelServer(config core.Config, server server.Server) ChannelServer {
-	return &httpChannelServer{
+	result := &httpChannelServer{
 		config: config,
 		server: server,
-		handler: &blockingHandler{
-			lock: &sync.RWMutex{},
-		},
 	}
+	result.listen = func() (net.Listener, error) {
+		host, port := networkConfig(config)
+		return net.Listen("tcp", fmt.Sprintf("%s:%s", host, port))
+	}
+	return result
 }
 
 func (self *httpChannelServer) Bind() (err error) {
-	rpc.RegisterName("Gate", self)
-	rpc.HandleHTTP()
+	self.rpc = rpc.NewServer()
+	err = self.rpc.RegisterName("Gate", self)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	self.ping = rpc.NewServer()
+	err = self.ping.RegisterName("Gate", &pingService{self.server})
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	self.tracker = &rpcTracker{}
+	self.http = &http.Server{Handler: self.restHandler()}
 
-	host, port := networkConfig(self.config)
-	endpoint := fmt.Sprintf("%s:%s", host, port)
-	self.listener, err = net.Listen("tcp", endpoint)
+	self.listener, err = self.listen()
 	if err != nil {
 		err = errors.Decorated(err)
 		return
 	}
 
-	go http.Serve(self.listener, self.handler)
+	// only after listening: don't spoil the session of a running server
+	self.session, err = newSession(self.config)
+	if err != nil {
+		self.listener.Close()
+		return
+	}
+
+	go func(httpServer *http.Server, listener net.Listener) {
+		err := httpServer.Serve(listener)
+		if err != http.ErrServerClosed {
+			log.Printf("HTTP server failed: %s", err)
+		}
+	}(self.http, self.listener)
 
 	return
 }
 
+func (self *httpChannelServer) serveRPC(w http.ResponseWriter, r *http.Request) {
+	if checkSession(r, self.session) {
+		self.tracker.serve(self.rpc, w, r)
+	} else {
+		logUnauthenticated(r)
+		self.tracker.serve(self.ping, w, r)
+	}
+}
+
+// Stop listening, and wait for the requests in flight (for a while)
 func (self *httpChannelServer) Disconnect() {
-	self.handler.lock.Lock() // will never unlock, but the server is dead anyway (this barrier ensures that all connections are served)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdown_delay)
+	defer cancel()
+	err := self.http.Shutdown(ctx)
+	if err != nil {
+		log.Printf("HTTP shutdown: %s", err)
+	}
+	// in case Serve did not start yet
+	self.listener.Close()
+	err = self.tracker.shutdown(ctx)
+	if err != nil {
+		log.Printf("RPC shutdown: %s", err)
+	}
 }
 
 func (self *httpChannelServer) IsOpen(thenClose bool, reply *bool) error {
@@ -127,6 +174,10 @@ func (self *httpChannelServer) Merge(args server.MergeArgs, reply *bool) error {
 	return self.server.Merge(args, reply)
 }
 
+func (self *httpChannelServer) Diff(args server.MergeArgs, reply *server.MergeDiff) error {
+	return self.server.Diff(args, reply)
+}
+
 func (self *httpChannelServer) Save(force bool, reply *bool) error {
 	return self.server.Save(force, reply)
 }
@@ -139,16 +190,12 @@ func (self *httpChannelServer) Unset(key string, reply *bool) error {
 	return self.server.Unset(key, reply)
 }
 
-func (self *httpChannelServer) Stop(status int, reply *bool) (err error) {
-	err = self.server.Stop(status, reply)
-	if err != nil {
-		return
-	}
-	err = self.listener.Close()
-	if err != nil {
-		return errors.Decorated(err)
-	}
-	return
+func (self *httpChannelServer) Stop(status int, reply *bool) error {
+	return self.server.Stop(status, reply)
+}
+
+func (self *httpChannelServer) Lock(reason string, reply *bool) error {
+	return self.server.Lock(reason, reply)
 }
 
 func (self *httpChannelServer) Ping(info string, reply *string) error {
@@ -159,38 +206,78 @@ func (self *httpChannelServer) SetMaster(master string, reply *bool) error {
 	return self.server.SetMaster(master, reply)
 }
 
+func (self *httpChannelServer) Info(args server.InfoArgs, reply *server.KeyInfo) error {
+	return self.server.Info(args, reply)
+}
+
+func (self *httpChannelServer) SetField(args server.SetFieldArgs, reply *bool) error {
+	return self.server.SetField(args, reply)
+}
+
+func (self *httpChannelServer) History(key string, reply *[]time.Time) error {
+	return self.server.History(key, reply)
+}
+
+func (self *httpChannelServer) Rollback(args server.RollbackArgs, reply *string) error {
+	return self.server.Rollback(args, reply)
+}
+
+func (self *httpChannelServer) Conflicts(filter string, reply *[]server.Conflict) error {
+	return self.server.Conflicts(filter, reply)
+}
+
+func (self *httpChannelServer) Resolve(args server.ResolveArgs, reply *bool) error {
+	return self.server.Resolve(args, reply)
+}
+
+func (self *httpChannelServer) Backups(filter string, reply *[]server.Backup) error {
+	return self.server.Backups(filter, reply)
+}
+
+func (self *httpChannelServer) RestoreBackup(name string, reply *bool) error {
+	return self.server.RestoreBackup(name, reply)
+}
+
+func (self *httpChannelServer) Verify(args server.VerifyArgs, reply *server.VerifyReport) error {
+	return self.server.Verify(args, reply)
+}
+
+func (self *httpChannelServer) Load(args server.MergeArgs, reply *bool) error {
+	return self.server.Load(args, reply)
+}
+
+func (self *httpChannelServer) Changes(vault string, reply *server.MergeDiff) error {
+	return self.server.Changes(vault, reply)
+}
+
 // ----------------------------------------------------------------
 
 func HttpChannelClient(config core.Config, startFunc server.ProxyStartFunc, proxy server.Server) ChannelClient {
 	return &httpChannelClient{
-		config: config,
-		proxy: proxy,
+		config:    config,
+		proxy:     proxy,
 		startFunc: startFunc,
+		dial: func() (*rpc.Client, error) {
+			host, port := networkConfig(config)
+			return dialHTTP("tcp", fmt.Sprintf("%s:%s", host, port), config)
+		},
 	}
 }
 
-func (self *httpChannelClient) Connect() (err error) {
-	host, port := networkConfig(self.config)
-	endpoint := fmt.Sprintf("%s:%d", host, port)
-	client, err := rpc.DialHTTP("tcp", endpoint)
+func (self *httpChannelClient) Connect() error {
+	return connectOrStart(self.connect, self.startFunc)
+}
+
+func (self *httpChannelClient) connect() (err error) {
+	self.client, err = self.dial()
 	if err != nil {
-		e := self.startFunc()
-		if e != nil {
-			err = errors.Decorated(e)
-			return
-		}
-		for delay := 100 * time.Millisecond; err != nil && delay <= 3*time.Second; delay *= 2 {
-			// if the server just started, maybe it needs time to settle
-			time.Sleep(delay)
-			client, err = rpc.DialHTTP("tcp", endpoint)
-		}
+		return
 	}
+	err = checkProtocol(self)
 	if err != nil {
-		err = errors.Decorated(err)
-		return
+		self.client.Close()
+		self.client = nil
 	}
-
-	self.client = client
 	return
 }
 
@@ -237,6 +324,14 @@ func (self *httpChannelClient) Merge(args server.MergeArgs, reply *bool) (err er
 	return
 }
 
+func (self *httpChannelClient) Diff(args server.MergeArgs, reply *server.MergeDiff) (err error) {
+	err = self.client.Call("Gate.Diff", args, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
 func (self *httpChannelClient) Save(force bool, reply *bool) (err error) {
 	err = self.client.Call("Gate.Save", force, reply)
 	if err != nil {
@@ -269,6 +364,14 @@ func (self *httpChannelClient) Stop(status int, reply *bool) (err error) {
 	return
 }
 
+func (self *httpChannelClient) Lock(reason string, reply *bool) (err error) {
+	err = self.client.Call("Gate.Lock", reason, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
 func (self *httpChannelClient) SetMaster(master string, reply *bool) (err error) {
 	err = self.client.Call("Gate.SetMaster", master, reply)
 	if err != nil {
@@ -284,3 +387,91 @@ func (self *httpChannelClient) Ping(info string, reply *string) (err error) {
 	}
 	return
 }
+
+func (self *httpChannelClient) Info(args server.InfoArgs, reply *server.KeyInfo) (err error) {
+	err = self.client.Call("Gate.Info", args, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+func (self *httpChannelClient) SetField(args server.SetFieldArgs, reply *bool) (err error) {
+	err = self.client.Call("Gate.SetField", args, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+func (self *httpChannelClient) History(key string, reply *[]time.Time) (err error) {
+	err = self.client.Call("Gate.History", key, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+func (self *httpChannelClient) Rollback(args server.RollbackArgs, reply *string) (err error) {
+	err = self.client.Call("Gate.Rollback", args, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+func (self *httpChannelClient) Conflicts(filter string, reply *[]server.Conflict) (err error) {
+	err = self.client.Call("Gate.Conflicts", filter, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+func (self *httpChannelClient) Resolve(args server.ResolveArgs, reply *bool) (err error) {
+	err = self.client.Call("Gate.Resolve", args, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+func (self *httpChannelClient) Backups(filter string, reply *[]server.Backup) (err error) {
+	err = self.client.Call("Gate.Backups", filter, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+func (self *httpChannelClient) RestoreBackup(name string, reply *bool) (err error) {
+	err = self.client.Call("Gate.RestoreBackup", name, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+func (self *httpChannelClient) Verify(args server.VerifyArgs, reply *server.VerifyReport) (err error) {
+	err = self.client.Call("Gate.Verify", args, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+func (self *httpChannelClient) Load(args server.MergeArgs, reply *bool) (err error) {
+	err = self.client.Call("Gate.Load", args, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+func (self *httpChannelClient) Changes(vault string, reply *server.MergeDiff) (err error) {
+	err = self.client.Call("Gate.Changes", vault, reply)
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
@@ -0,0 +1,146 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+import (
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestHttpChannel(t *testing.T, ctrl *gomock.Controller, address string) (channelServer *httpChannelServer, channelClient *httpChannelClient, srv *server.MockServer, cleanup func()) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		os.RemoveAll(dir)
+	}
+
+	cfg := core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+
+	srv = server.NewMockServer(ctrl)
+	srv.EXPECT().Ping(server.ProtocolQuery, gomock.Any()).Do(func(info string, reply *string) {
+		*reply = server.ProtocolVersion
+	}).AnyTimes()
+
+	channelServer = HttpChannelServer(cfg, srv).(*httpChannelServer)
+	channelServer.listen = func() (net.Listener, error) {
+		return net.Listen("tcp", address)
+	}
+	channelClient = HttpChannelClient(cfg, func() error {
+		t.Errorf("unexpected server start")
+		return nil
+	}, nil).(*httpChannelClient)
+	channelClient.dial = func() (*rpc.Client, error) {
+		return dialHTTP("tcp", channelServer.listener.Addr().String(), cfg)
+	}
+	return
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	channelServer, channelClient, srv, cleanup := newTestHttpChannel(t, ctrl, "127.0.0.1:0")
+	defer cleanup()
+
+	started := make(chan bool)
+	srv.EXPECT().Get("foo", gomock.Any()).Do(func(name string, reply *string) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		*reply = "bar"
+	})
+
+	err := channelServer.Bind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = channelClient.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	var reply string
+	go func() {
+		done <- channelClient.Get("foo", &reply)
+	}()
+
+	<-started
+	channelServer.Disconnect()
+
+	err = <-done
+	if err != nil {
+		t.Errorf("call in flight failed: %s", err)
+	}
+	if reply != "bar" {
+		t.Errorf("bad reply: '%s'", reply)
+	}
+
+	err = channelClient.Get("foo", &reply)
+	if err == nil {
+		t.Errorf("call accepted after shutdown")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// find a free port, then use it again and again
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	for i := 0; i < 3; i++ {
+		channelServer, channelClient, srv, cleanup := newTestHttpChannel(t, ctrl, address)
+		srv.EXPECT().Stop(0, gomock.Any()).Do(func(status int, reply *bool) {
+			*reply = true
+		})
+
+		err = channelServer.Bind()
+		if err != nil {
+			t.Fatalf("bind #%d: %s", i, err)
+		}
+		err = channelClient.Connect()
+		if err != nil {
+			t.Fatalf("connect #%d: %s", i, err)
+		}
+		var stopped bool
+		err = channelClient.Stop(0, &stopped)
+		if err != nil {
+			t.Errorf("stop #%d: %s", i, err)
+		}
+		channelServer.Disconnect()
+		cleanup()
+	}
+}
@@ -18,9 +18,15 @@ package channel
 // Channel interfaces
 
 import (
+	"gate/core"
+	"gate/core/errors"
 	"gate/server"
 )
 
+import (
+	"os"
+)
+
 // General channel interface
 type Channel interface {
 	Disconnect()
@@ -39,3 +45,95 @@ type ChannelClient interface {
 	Channel
 	Connect() error
 }
+
+// Build the server-side channel of a transport
+type ServerFactory func(config core.Config, server server.Server) ChannelServer
+
+// Build the client-side channel of a transport
+type ClientFactory func(config core.Config, startFunc server.ProxyStartFunc, proxy server.Server) ChannelClient
+
+type transportFactories struct {
+	server ServerFactory
+	client ClientFactory
+}
+
+var transports = make(map[string]transportFactories)
+
+// Register a transport, selected by the [connection] transport
+// configuration value
+func RegisterTransport(name string, serverFactory ServerFactory, clientFactory ClientFactory) {
+	transports[name] = transportFactories{
+		server: serverFactory,
+		client: clientFactory,
+	}
+}
+
+// The transport configured in the [connection] section ("http" by
+// default)
+func transport(config core.Config) string {
+	result, err := config.Eval("", "connection", "transport", os.Getenv)
+	if err != nil || result == "" {
+		result = "http"
+	}
+	return result
+}
+
+// Return the server-side channel of the configured transport
+func NewChannelServer(config core.Config, server server.Server) (result ChannelServer, err error) {
+	t := transport(config)
+	factories, ok := transports[t]
+	if !ok {
+		err = errors.Newf("Unknown transport: %s", t)
+		return
+	}
+	result = factories.server(config, server)
+	return
+}
+
+// Return the client-side channel of the configured transport
+func NewChannelClient(config core.Config, startFunc server.ProxyStartFunc, proxy server.Server) (result ChannelClient, err error) {
+	t := transport(config)
+	factories, ok := transports[t]
+	if !ok {
+		err = errors.Newf("Unknown transport: %s", t)
+		return
+	}
+	result = factories.client(config, startFunc, proxy)
+	return
+}
+
+// Connect using the connect function; if it fails, start the server
+// and try again (startFunc returns when the server is ready)
+func connectOrStart(connect func() error, startFunc server.ProxyStartFunc) (err error) {
+	err = connect()
+	if err != nil {
+		e := startFunc()
+		if e != nil {
+			err = errors.Decorated(e)
+			return
+		}
+		err = connect()
+	}
+	if err != nil {
+		err = errors.Decorated(err)
+	}
+	return
+}
+
+// Check that the server speaks the same protocol version as the
+// client. A stale server (started by an older binary) is stopped, so
+// that a new one may be started (the start waits until the stale one
+// is gone, see daemon.Start).
+func checkProtocol(client server.Server) (err error) {
+	var version string
+	err = client.Ping(server.ProtocolQuery, &version)
+	if err != nil {
+		return
+	}
+	if version != server.ProtocolVersion {
+		var stopped bool
+		client.Stop(0, &stopped)
+		err = errors.Newf("Stale server stopped (protocol version '%s', expected '%s')", version, server.ProtocolVersion)
+	}
+	return
+}
@@ -0,0 +1,140 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+import (
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"testing"
+)
+
+func TestUnknownTransport(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	cfg.EXPECT().Eval("", "connection", "transport", gomock.Any()).Return("carrier-pigeon", nil).AnyTimes()
+
+	_, err := NewChannelServer(cfg, nil)
+	if err == nil {
+		t.Errorf("unknown transport accepted by the server")
+	}
+	_, err = NewChannelClient(cfg, nil, nil)
+	if err == nil {
+		t.Errorf("unknown transport accepted by the client")
+	}
+}
+
+func TestRegisteredTransport(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	cfg.EXPECT().Eval("", "connection", "transport", gomock.Any()).Return("test", nil).AnyTimes()
+
+	channelServer := &httpChannelServer{}
+	channelClient := &httpChannelClient{}
+	RegisterTransport("test", func(core.Config, server.Server) ChannelServer {
+		return channelServer
+	}, func(core.Config, server.ProxyStartFunc, server.Server) ChannelClient {
+		return channelClient
+	})
+	defer delete(transports, "test")
+
+	s, err := NewChannelServer(cfg, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if s != channelServer {
+		t.Errorf("bad server channel")
+	}
+	c, err := NewChannelClient(cfg, nil, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if c != channelClient {
+		t.Errorf("bad client channel")
+	}
+}
+
+func TestStaleServer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+
+	bind := func(srv server.Server) string {
+		channelServer := HttpChannelServer(cfg, srv).(*httpChannelServer)
+		channelServer.listen = func() (net.Listener, error) {
+			return net.Listen("tcp", "127.0.0.1:0")
+		}
+		err := channelServer.Bind()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return channelServer.listener.Addr().String()
+	}
+
+	// an older server just echoes the ping
+	stale := server.NewMockServer(ctrl)
+	stale.EXPECT().Ping(server.ProtocolQuery, gomock.Any()).Do(func(info string, reply *string) {
+		*reply = info
+	})
+	stale.EXPECT().Stop(0, gomock.Any()).Do(func(status int, reply *bool) {
+		*reply = true
+	})
+	address := bind(stale)
+
+	current := server.NewMockServer(ctrl)
+	current.EXPECT().Ping(server.ProtocolQuery, gomock.Any()).Do(func(info string, reply *string) {
+		*reply = server.ProtocolVersion
+	})
+
+	started := false
+	start := func() error {
+		started = true
+		address = bind(current)
+		return nil
+	}
+	client := HttpChannelClient(cfg, start, nil).(*httpChannelClient)
+	client.dial = func() (*rpc.Client, error) {
+		return dialHTTP("tcp", address, cfg)
+	}
+	err = client.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !started {
+		t.Errorf("stale server not replaced")
+	}
+}
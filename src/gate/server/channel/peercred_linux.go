@@ -0,0 +1,48 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+import (
+	"gate/core/errors"
+)
+
+import (
+	"net"
+	"syscall"
+)
+
+// The uid of the process connected to the other end of the socket
+func peerUid(conn *net.UnixConn) (result int, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1, errors.Decorated(err)
+	}
+	var (
+		cred *syscall.Ucred
+		e    error
+	)
+	err = raw.Control(func(fd uintptr) {
+		cred, e = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = e
+	}
+	if err != nil {
+		return -1, errors.Decorated(err)
+	}
+	result = int(cred.Uid)
+	return
+}
@@ -0,0 +1,193 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+// A small JSON REST API, served by the http channel next to the RPC,
+// for third-party integrations (browser extensions, scripts...):
+//
+//   GET  /keys?filter=<regexp>  the list of key names
+//   GET  /keys/<name>           the key, with its password
+//   PUT  /keys/<name>           set the key; the body is {"password":...}
+//                               or {"recipe":...} (generated password)
+//   POST /lock                  lock the vault
+//
+// Requests must carry the session secret in the X-Gate-Session header.
+
+import (
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const status_locked = 423 // WebDAV's "Locked"
+
+type restKey struct {
+	Name     string            `json:"name"`
+	Username string            `json:"username,omitempty"`
+	Url      string            `json:"url,omitempty"`
+	Notes    string            `json:"notes,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Created  *time.Time        `json:"created,omitempty"`
+	Modified *time.Time        `json:"modified,omitempty"`
+	Password string            `json:"password"`
+}
+
+type restSetKey struct {
+	Password string `json:"password"`
+	Recipe   string `json:"recipe"`
+}
+
+type restLock struct {
+	Locked bool `json:"locked"`
+}
+
+type restError struct {
+	Error string `json:"error"`
+}
+
+func (self *httpChannelServer) restHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys", self.restAuthenticated(self.restKeys))
+	mux.HandleFunc("/keys/", self.restAuthenticated(self.restKey))
+	mux.HandleFunc("/lock", self.restAuthenticated(self.restLock))
+	mux.HandleFunc("/", self.serveRPC)
+	return mux
+}
+
+func (self *httpChannelServer) restAuthenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkSession(r, self.session) {
+			handler(w, r)
+		} else {
+			logUnauthenticated(r)
+			writeJson(w, http.StatusUnauthorized, &restError{"Missing or bad session"})
+		}
+	}
+}
+
+func (self *httpChannelServer) restKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeJson(w, http.StatusMethodNotAllowed, &restError{"Method not allowed"})
+		return
+	}
+	var list []string
+	err := self.server.List(r.URL.Query().Get("filter"), &list)
+	if err != nil {
+		writeRestError(w, err)
+		return
+	}
+	if list == nil {
+		list = []string{}
+	}
+	writeJson(w, http.StatusOK, list)
+}
+
+func (self *httpChannelServer) restKey(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/keys/")
+	if name == "" {
+		writeJson(w, http.StatusNotFound, &restError{"Missing key name"})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		var info server.KeyInfo
+		err := self.server.Info(server.InfoArgs{Key: name, WithPassword: true}, &info)
+		if err != nil {
+			writeRestError(w, err)
+			return
+		}
+		key := &restKey{
+			Name:     info.Name,
+			Username: info.Username,
+			Url:      info.Url,
+			Notes:    info.Notes,
+			Fields:   info.Fields,
+			Password: info.Password,
+		}
+		if !info.Created.IsZero() {
+			key.Created = &info.Created
+		}
+		if !info.Modified.IsZero() {
+			key.Modified = &info.Modified
+		}
+		writeJson(w, http.StatusOK, key)
+	case "PUT":
+		var args restSetKey
+		err := json.NewDecoder(r.Body).Decode(&args)
+		if err != nil {
+			writeJson(w, http.StatusBadRequest, &restError{err.Error()})
+			return
+		}
+		if args.Password == "" && args.Recipe == "" {
+			// never store an empty password
+			writeJson(w, http.StatusBadRequest, &restError{"Missing password or recipe"})
+			return
+		}
+		var pass string
+		err = self.server.Set(server.SetArgs{Key: name, Pass: args.Password, Recipe: args.Recipe}, &pass)
+		if err != nil {
+			writeRestError(w, err)
+			return
+		}
+		writeJson(w, http.StatusOK, &restKey{Name: name, Password: pass})
+	default:
+		writeJson(w, http.StatusMethodNotAllowed, &restError{"Method not allowed"})
+	}
+}
+
+func (self *httpChannelServer) restLock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJson(w, http.StatusMethodNotAllowed, &restError{"Method not allowed"})
+		return
+	}
+	var locked bool
+	err := self.server.Lock("rest", &locked)
+	if err != nil {
+		writeRestError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, &restLock{locked})
+}
+
+func writeRestError(w http.ResponseWriter, err error) {
+	if e, ok := err.(errors.StackError); ok {
+		err = e.Nested
+	}
+	message := err.Error()
+	status := http.StatusInternalServerError
+	switch {
+	case server.IsLocked(err):
+		status = status_locked
+	case strings.HasPrefix(message, "Unknown key"):
+		status = http.StatusNotFound
+	case strings.HasPrefix(message, "Vault is not open"):
+		status = http.StatusServiceUnavailable
+	}
+	writeJson(w, status, &restError{message})
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,195 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+import (
+	"gate/core"
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+type restTest struct {
+	t       *testing.T
+	address string
+	session string
+}
+
+func (self *restTest) do(method, path, body string, session string) (status int, result map[string]interface{}, list []string) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", self.address, path), reader)
+	if err != nil {
+		self.t.Fatal(err)
+	}
+	if session != "" {
+		req.Header.Set(session_header, session)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		self.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/json" {
+		self.t.Errorf("%s %s: bad content type '%s'", method, path, resp.Header.Get("Content-Type"))
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		self.t.Fatal(err)
+	}
+	if strings.HasPrefix(string(data), "[") {
+		err = json.Unmarshal(data, &list)
+	} else {
+		err = json.Unmarshal(data, &result)
+	}
+	if err != nil {
+		self.t.Errorf("%s %s: bad JSON '%s': %s", method, path, data, err)
+	}
+	status = resp.StatusCode
+	return
+}
+
+func TestRest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().List("^f", gomock.Any()).Do(func(filter string, reply *[]string) {
+		*reply = []string{"foo", "fuu"}
+	})
+	srv.EXPECT().Info(server.InfoArgs{Key: "foo", WithPassword: true}, gomock.Any()).Do(func(args server.InfoArgs, reply *server.KeyInfo) {
+		*reply = server.KeyInfo{
+			Name:     "foo",
+			Username: "me",
+			Password: "bar",
+		}
+	})
+	srv.EXPECT().Info(server.InfoArgs{Key: "nope", WithPassword: true}, gomock.Any()).Return(errors.New("Unknown key nope"))
+	srv.EXPECT().Set(server.SetArgs{Key: "foo", Recipe: "16an"}, gomock.Any()).Do(func(args server.SetArgs, reply *string) {
+		*reply = "generated"
+	})
+	srv.EXPECT().Lock("rest", gomock.Any()).Do(func(reason string, reply *bool) {
+		*reply = true
+	})
+	srv.EXPECT().List("", gomock.Any()).Return(errors.New(server.LockedMessage))
+
+	channelServer := HttpChannelServer(cfg, srv).(*httpChannelServer)
+	channelServer.listen = func() (net.Listener, error) {
+		return net.Listen("tcp", "127.0.0.1:0")
+	}
+	err = channelServer.Bind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest := &restTest{t, channelServer.listener.Addr().String(), readSession(cfg)}
+
+	status, _, _ := rest.do("GET", "/keys", "", "")
+	if status != http.StatusUnauthorized {
+		t.Errorf("unauthenticated request: bad status %d", status)
+	}
+	status, _, _ = rest.do("GET", "/keys", "", "bad")
+	if status != http.StatusUnauthorized {
+		t.Errorf("bad session: bad status %d", status)
+	}
+
+	status, _, list := rest.do("GET", "/keys?filter=%5Ef", "", rest.session)
+	if status != http.StatusOK {
+		t.Errorf("list: bad status %d", status)
+	}
+	if len(list) != 2 || list[0] != "foo" || list[1] != "fuu" {
+		t.Errorf("list: bad result %v", list)
+	}
+
+	status, key, _ := rest.do("GET", "/keys/foo", "", rest.session)
+	if status != http.StatusOK {
+		t.Errorf("get: bad status %d", status)
+	}
+	if key["name"] != "foo" || key["username"] != "me" || key["password"] != "bar" {
+		t.Errorf("get: bad result %v", key)
+	}
+
+	status, key, _ = rest.do("GET", "/keys/nope", "", rest.session)
+	if status != http.StatusNotFound {
+		t.Errorf("get unknown: bad status %d", status)
+	}
+	if key["error"] != "Unknown key nope" {
+		t.Errorf("get unknown: bad result %v", key)
+	}
+
+	status, key, _ = rest.do("PUT", "/keys/foo", `{"recipe":"16an"}`, rest.session)
+	if status != http.StatusOK {
+		t.Errorf("set: bad status %d", status)
+	}
+	if key["name"] != "foo" || key["password"] != "generated" {
+		t.Errorf("set: bad result %v", key)
+	}
+
+	status, _, _ = rest.do("PUT", "/keys/foo", `not json`, rest.session)
+	if status != http.StatusBadRequest {
+		t.Errorf("set garbage: bad status %d", status)
+	}
+
+	status, key, _ = rest.do("PUT", "/keys/foo", `{}`, rest.session)
+	if status != http.StatusBadRequest {
+		t.Errorf("set nothing: bad status %d", status)
+	}
+	if key["error"] != "Missing password or recipe" {
+		t.Errorf("set nothing: bad result %v", key)
+	}
+
+	status, _, _ = rest.do("GET", "/lock", "", rest.session)
+	if status != http.StatusMethodNotAllowed {
+		t.Errorf("get lock: bad status %d", status)
+	}
+
+	status, lock, _ := rest.do("POST", "/lock", "", rest.session)
+	if status != http.StatusOK {
+		t.Errorf("lock: bad status %d", status)
+	}
+	if lock["locked"] != true {
+		t.Errorf("lock: bad result %v", lock)
+	}
+
+	status, _, _ = rest.do("GET", "/keys", "", rest.session)
+	if status != status_locked {
+		t.Errorf("list locked: bad status %d", status)
+	}
+}
@@ -0,0 +1,173 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+// The RPC connections of the http channel. They are hijacked from the
+// http server, which does not track them anymore; the calls in flight
+// are counted here so that the shutdown can wait for them.
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"io"
+	"log"
+	"net/http"
+	"net/rpc"
+	"sync"
+)
+
+type rpcTracker struct {
+	lock    sync.Mutex
+	calls   int
+	closing bool
+	idle    chan bool
+	conns   map[io.Closer]bool
+}
+
+// Start a call; false if the channel is shutting down
+func (self *rpcTracker) begin() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closing {
+		return false
+	}
+	self.calls++
+	return true
+}
+
+func (self *rpcTracker) end() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.calls--
+	if self.calls == 0 && self.idle != nil {
+		close(self.idle)
+		self.idle = nil
+	}
+}
+
+func (self *rpcTracker) add(conn io.Closer) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.conns == nil {
+		self.conns = make(map[io.Closer]bool)
+	}
+	self.conns[conn] = true
+}
+
+func (self *rpcTracker) remove(conn io.Closer) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.conns, conn)
+}
+
+// Refuse new calls, wait for the calls in flight (or the end of the
+// context), then close all the connections
+func (self *rpcTracker) shutdown(ctx context.Context) (err error) {
+	self.lock.Lock()
+	self.closing = true
+	var idle chan bool
+	if self.calls > 0 {
+		idle = make(chan bool)
+		self.idle = idle
+	}
+	self.lock.Unlock()
+
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for conn := range self.conns {
+		conn.Close()
+	}
+	self.conns = nil
+	return
+}
+
+// Like rpc.Server.ServeHTTP, but the connection is tracked
+func (self *rpcTracker) serve(server *rpc.Server, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "CONNECT" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		log.Printf("RPC hijacking %s: %s", r.RemoteAddr, err)
+		return
+	}
+	io.WriteString(conn, "HTTP/1.0 200 Connected to Go RPC\n\n")
+
+	self.add(conn)
+	defer self.remove(conn)
+	buf := bufio.NewWriter(conn)
+	server.ServeCodec(&trackedCodec{
+		tracker: self,
+		rwc:     conn,
+		dec:     gob.NewDecoder(conn),
+		enc:     gob.NewEncoder(buf),
+		buf:     buf,
+	})
+}
+
+// The same gob encoding as net/rpc, counting the calls
+type trackedCodec struct {
+	tracker *rpcTracker
+	rwc     io.ReadWriteCloser
+	dec     *gob.Decoder
+	enc     *gob.Encoder
+	buf     *bufio.Writer
+}
+
+func (self *trackedCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	err = self.dec.Decode(r)
+	if err == nil && !self.tracker.begin() {
+		// too late, the channel is shutting down
+		err = io.EOF
+	}
+	return
+}
+
+func (self *trackedCodec) ReadRequestBody(body interface{}) error {
+	return self.dec.Decode(body)
+}
+
+// Always called once per call after a successful ReadRequestHeader
+func (self *trackedCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	defer self.tracker.end()
+	err = self.enc.Encode(r)
+	if err == nil {
+		err = self.enc.Encode(body)
+	}
+	if err == nil {
+		err = self.buf.Flush()
+	}
+	if err != nil {
+		self.rwc.Close()
+	}
+	return
+}
+
+func (self *trackedCodec) Close() error {
+	return self.rwc.Close()
+}
@@ -0,0 +1,140 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+// Session authentication: the server generates a random secret when it
+// starts, and writes it in a file only readable by the user. Clients
+// send that secret when connecting; unauthenticated connections may
+// only Ping.
+
+import (
+	"gate/core"
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"strings"
+)
+
+const (
+	session_header = "X-Gate-Session"
+	session_size   = 32
+)
+
+func sessionPath(config core.Config) (result string, err error) {
+	xdg, err := config.Xdg()
+	if err != nil {
+		return
+	}
+	dir, err := xdg.RuntimeDir()
+	if err != nil {
+		return
+	}
+	result = fmt.Sprintf("%s/gate-session", dir)
+	return
+}
+
+// Generate a new session secret and write it to the session file
+func newSession(config core.Config) (result string, err error) {
+	path, err := sessionPath(config)
+	if err != nil {
+		return
+	}
+
+	secret := make([]byte, session_size)
+	_, err = io.ReadFull(rand.Reader, secret)
+	if err != nil {
+		return "", errors.Decorated(err)
+	}
+	result = hex.EncodeToString(secret)
+
+	os.Remove(path)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", errors.Decorated(err)
+	}
+	defer file.Close()
+	_, err = file.WriteString(result)
+	if err != nil {
+		return "", errors.Decorated(err)
+	}
+	return
+}
+
+// Read the session secret written by the server; empty if there is
+// none (the server is not started)
+func readSession(config core.Config) (result string) {
+	path, err := sessionPath(config)
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	result = strings.TrimSpace(string(data))
+	return
+}
+
+func checkSession(r *http.Request, session string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(session_header)), []byte(session)) == 1
+}
+
+// The only service available to unauthenticated connections
+type pingService struct {
+	server server.Server
+}
+
+func (self *pingService) Ping(info string, reply *string) error {
+	return self.server.Ping(info, reply)
+}
+
+// Like rpc.DialHTTP, but sends the session secret
+func dialHTTP(network, address string, config core.Config) (result *rpc.Client, err error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.0\r\n%s: %s\r\n\r\n", rpc.DefaultRPCPath, session_header, readSession(config))
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.StatusCode == http.StatusOK {
+		result = rpc.NewClient(conn)
+		return
+	}
+	if err == nil {
+		err = errors.Newf("Unexpected HTTP response: %s", resp.Status)
+	}
+	conn.Close()
+	return
+}
+
+func logUnauthenticated(r *http.Request) {
+	log.Printf("Unauthenticated connection from %s: only Ping is allowed", r.RemoteAddr)
+}
@@ -0,0 +1,103 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+import (
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Ping("hello", gomock.Any()).Do(func(info string, reply *string) {
+		*reply = info
+	})
+	srv.EXPECT().Get("foo", gomock.Any()).Do(func(name string, reply *string) {
+		*reply = "bar"
+	})
+
+	channelServer := HttpChannelServer(cfg, srv).(*httpChannelServer)
+	channelServer.listen = func() (net.Listener, error) {
+		return net.Listen("tcp", "127.0.0.1:0")
+	}
+	err = channelServer.Bind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := channelServer.listener.Addr().String()
+
+	info, err := os.Stat(dir + "/gate-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("session file not private: %v", info.Mode())
+	}
+
+	// without the session secret: only Ping
+	anonymous, err := rpc.DialHTTP("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	err = anonymous.Call("Gate.Ping", "hello", &reply)
+	if err != nil {
+		t.Error(err)
+	}
+	if reply != "hello" {
+		t.Errorf("bad reply: '%s'", reply)
+	}
+	err = anonymous.Call("Gate.Get", "foo", &reply)
+	if err == nil {
+		t.Errorf("unauthenticated Get accepted")
+	}
+
+	// with the session secret
+	client, err := dialHTTP("tcp", address, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Call("Gate.Get", "foo", &reply)
+	if err != nil {
+		t.Error(err)
+	}
+	if reply != "bar" {
+		t.Errorf("bad reply: '%s'", reply)
+	}
+}
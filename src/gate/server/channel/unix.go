@@ -0,0 +1,145 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+// Unix domain socket channel: the same RPC as the http channel, on a
+// socket in the runtime directory, only accessible to the user running
+// the server
+
+import (
+	"gate/core"
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+)
+
+func init() {
+	RegisterTransport("unix", UnixChannelServer, UnixChannelClient)
+}
+
+// Only accepts connections from the same user
+type peerListener struct {
+	*net.UnixListener
+	uid int
+}
+
+func (self *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := self.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUid(conn)
+		if err == nil && uid == self.uid {
+			return conn, nil
+		}
+		if err != nil {
+			log.Printf("Rejected connection: %s", err)
+		} else {
+			log.Printf("Rejected connection from uid %d", uid)
+		}
+		conn.Close()
+	}
+}
+
+// The socket path, in the runtime directory (made private if needed)
+func socketPath(config core.Config) (result string, err error) {
+	xdg, err := config.Xdg()
+	if err != nil {
+		return
+	}
+	dir, err := xdg.RuntimeDir()
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", errors.Decorated(err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		err = os.Chmod(dir, 0700)
+		if err != nil {
+			return "", errors.Decorated(err)
+		}
+	}
+	result = fmt.Sprintf("%s/gate.sock", dir)
+	return
+}
+
+func listenUnix(config core.Config) (result net.Listener, err error) {
+	path, err := socketPath(config)
+	if err != nil {
+		return
+	}
+
+	if _, e := os.Stat(path); e == nil {
+		conn, e := net.Dial("unix", path)
+		if e == nil {
+			conn.Close()
+			return nil, errors.Newf("A server is already listening on %s", path)
+		}
+		// stale socket, left by a dead server
+		err = os.Remove(path)
+		if err != nil {
+			return nil, errors.Decorated(err)
+		}
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, errors.Decorated(err)
+	}
+
+	result = &peerListener{listener, os.Getuid()}
+	return
+}
+
+func UnixChannelServer(config core.Config, server server.Server) ChannelServer {
+	return &httpChannelServer{
+		config: config,
+		server: server,
+		listen: func() (net.Listener, error) {
+			return listenUnix(config)
+		},
+	}
+}
+
+func UnixChannelClient(config core.Config, startFunc server.ProxyStartFunc, proxy server.Server) ChannelClient {
+	return &httpChannelClient{
+		config:    config,
+		proxy:     proxy,
+		startFunc: startFunc,
+		dial: func() (result *rpc.Client, err error) {
+			path, err := socketPath(config)
+			if err != nil {
+				return
+			}
+			return dialHTTP("unix", path, config)
+		},
+	}
+}
@@ -0,0 +1,100 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+import (
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUnixChannel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Chmod(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Ping(server.ProtocolQuery, gomock.Any()).Do(func(info string, reply *string) {
+		*reply = server.ProtocolVersion
+	})
+	srv.EXPECT().Ping("hello", gomock.Any()).Do(func(info string, reply *string) {
+		*reply = info
+	})
+
+	channelServer := UnixChannelServer(cfg, srv)
+	err = channelServer.Bind()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("runtime directory not private: %v", info.Mode())
+	}
+	info, err = os.Stat(dir + "/gate.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("socket not private: %v", info.Mode())
+	}
+
+	start := func() error {
+		t.Errorf("unexpected server start")
+		return nil
+	}
+	client := UnixChannelClient(cfg, start, nil)
+	err = client.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	err = client.Ping("hello", &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "hello" {
+		t.Errorf("bad reply: '%s'", reply)
+	}
+
+	_, err = UnixChannelServer(cfg, srv).(*httpChannelServer).listen()
+	if err == nil {
+		t.Errorf("two servers listening on the same socket")
+	}
+}
@@ -15,7 +15,12 @@
 
 package channel
 
-// 0mq channel implementation - not finished!!!
+// 0mq channel implementation: a REQ/REP pair of sockets.
+//
+// Each request is a gob stream of a zmqRequest followed by the
+// operation argument; each response is a gob stream of a zmqResponse
+// followed, if there is no error, by the operation reply. The
+// operations are the methods of server.Server, named after them.
 
 import (
 	"gate/core"
@@ -24,38 +29,186 @@ import (
 )
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/gob"
 	"fmt"
 	zmq "github.com/pebbe/zmq4"
-	"os"
+	"log"
+	"reflect"
 	"sync"
+	"syscall"
 	"time"
 )
 
+const (
+	zmq_poll_delay    = 100 * time.Millisecond
+	zmq_connect_delay = time.Second
+	zmq_call_delay    = time.Minute
+)
+
+type zmqRequest struct {
+	Session   string
+	Operation string
+}
+
+type zmqResponse struct {
+	Error string
+}
+
 type zmqChannelServer struct {
-	config core.Config
-	server server.Server
+	config   core.Config
+	server   server.Server
+	endpoint string
+	session  string
+	socket   *zmq.Socket
+	lock     sync.Mutex
+	stopping bool
+	done     chan bool
 }
 
 type zmqChannelClient struct {
-	config core.Config
-	proxy  server.Server
+	config    core.Config
+	proxy     server.Server
+	startFunc server.ProxyStartFunc
+	endpoint  string
+	session   string
+	socket    *zmq.Socket
+	lock      sync.Mutex
+}
+
+var _ ChannelServer = &zmqChannelServer{}
+var _ ChannelClient = &zmqChannelClient{}
+
+var serverType = reflect.TypeOf((*server.Server)(nil)).Elem()
+
+func init() {
+	RegisterTransport("zmq", ZmqChannelServer, ZmqChannelClient)
+}
+
+func zmqEndpoint(config core.Config) string {
+	host, port := networkConfig(config)
+	return fmt.Sprintf("tcp://%s:%s", host, port)
 }
 
 // ----------------------------------------------------------------
 
 func ZmqChannelServer(config core.Config, server server.Server) ChannelServer {
 	return &zmqChannelServer{
-		config: config,
-		server: server,
+		config:   config,
+		server:   server,
+		endpoint: zmqEndpoint(config),
 	}
 }
 
 func (self *zmqChannelServer) Bind() (err error) {
-	host, port := networkConfig(self.config)
+	socket, err := zmq.NewSocket(zmq.REP)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	err = socket.SetRcvtimeo(zmq_poll_delay)
+	if err == nil {
+		err = socket.Bind(self.endpoint)
+	}
+	if err != nil {
+		socket.Close()
+		return errors.Decorated(err)
+	}
+
+	// only after binding: don't spoil the session of a running server
+	self.session, err = newSession(self.config)
+	if err != nil {
+		socket.Close()
+		return
+	}
+
+	self.socket = socket
+	self.done = make(chan bool)
+	go self.serve()
+
 	return
 }
 
+func (self *zmqChannelServer) isStopping() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.stopping
+}
+
+func (self *zmqChannelServer) serve() {
+	defer close(self.done)
+	defer self.socket.Close()
+	for !self.isStopping() {
+		request, err := self.socket.RecvBytes(0)
+		if err != nil {
+			if zmq.AsErrno(err) != zmq.Errno(syscall.EAGAIN) {
+				log.Printf("0mq receive failed: %s", err)
+			}
+			continue
+		}
+		_, err = self.socket.SendBytes(self.dispatch(request), 0)
+		if err != nil {
+			log.Printf("0mq send failed: %s", err)
+		}
+	}
+}
+
+func (self *zmqChannelServer) dispatch(request []byte) []byte {
+	var response zmqResponse
+	var result bytes.Buffer
+	var reply reflect.Value
+
+	dec := gob.NewDecoder(bytes.NewReader(request))
+	err := func() error {
+		var req zmqRequest
+		err := dec.Decode(&req)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(req.Session), []byte(self.session)) != 1 && req.Operation != "Ping" {
+			log.Printf("Unauthenticated request: only Ping is allowed")
+			return errors.New("Unauthenticated request: only Ping is allowed")
+		}
+		method, ok := serverType.MethodByName(req.Operation)
+		if !ok {
+			return errors.Newf("Unknown operation: %s", req.Operation)
+		}
+		arg := reflect.New(method.Type.In(0))
+		err = dec.Decode(arg.Interface())
+		if err != nil {
+			return err
+		}
+		reply = reflect.New(method.Type.In(1).Elem())
+		out := reflect.ValueOf(self).MethodByName(method.Name).Call([]reflect.Value{arg.Elem(), reply})
+		if e, ok := out[0].Interface().(error); ok && e != nil {
+			return e
+		}
+		return nil
+	}()
+
+	enc := gob.NewEncoder(&result)
+	if err != nil {
+		if e, ok := err.(errors.StackError); ok {
+			err = e.Nested
+		}
+		response.Error = err.Error()
+		enc.Encode(&response)
+	} else {
+		enc.Encode(&response)
+		enc.EncodeValue(reply)
+	}
+	return result.Bytes()
+}
+
 func (self *zmqChannelServer) Disconnect() {
+	self.lock.Lock()
+	self.stopping = true
+	self.lock.Unlock()
+	if self.done == nil {
+		// not bound: nothing to wait for
+		return
+	}
+	<-self.done
 }
 
 func (self *zmqChannelServer) IsOpen(thenClose bool, reply *bool) error {
@@ -78,6 +231,10 @@ func (self *zmqChannelServer) Merge(args server.MergeArgs, reply *bool) error {
 	return self.server.Merge(args, reply)
 }
 
+func (self *zmqChannelServer) Diff(args server.MergeArgs, reply *server.MergeDiff) error {
+	return self.server.Diff(args, reply)
+}
+
 func (self *zmqChannelServer) Save(force bool, reply *bool) error {
 	return self.server.Save(force, reply)
 }
@@ -90,8 +247,20 @@ func (self *zmqChannelServer) Unset(key string, reply *bool) error {
 	return self.server.Unset(key, reply)
 }
 
-func (self *zmqChannelServer) Stop(status int, reply *bool) error {
-	return self.server.Stop(status, reply)
+func (self *zmqChannelServer) Stop(status int, reply *bool) (err error) {
+	err = self.server.Stop(status, reply)
+	if err != nil {
+		return
+	}
+	// the serving loop stops after sending this reply
+	self.lock.Lock()
+	self.stopping = true
+	self.lock.Unlock()
+	return
+}
+
+func (self *zmqChannelServer) Lock(reason string, reply *bool) error {
+	return self.server.Lock(reason, reply)
 }
 
 func (self *zmqChannelServer) Ping(info string, reply *string) error {
@@ -102,64 +271,273 @@ func (self *zmqChannelServer) SetMaster(master string, reply *bool) error {
 	return self.server.SetMaster(master, reply)
 }
 
+func (self *zmqChannelServer) Info(args server.InfoArgs, reply *server.KeyInfo) error {
+	return self.server.Info(args, reply)
+}
+
+func (self *zmqChannelServer) SetField(args server.SetFieldArgs, reply *bool) error {
+	return self.server.SetField(args, reply)
+}
+
+func (self *zmqChannelServer) History(key string, reply *[]time.Time) error {
+	return self.server.History(key, reply)
+}
+
+func (self *zmqChannelServer) Rollback(args server.RollbackArgs, reply *string) error {
+	return self.server.Rollback(args, reply)
+}
+
+func (self *zmqChannelServer) Conflicts(filter string, reply *[]server.Conflict) error {
+	return self.server.Conflicts(filter, reply)
+}
+
+func (self *zmqChannelServer) Resolve(args server.ResolveArgs, reply *bool) error {
+	return self.server.Resolve(args, reply)
+}
+
+func (self *zmqChannelServer) Backups(filter string, reply *[]server.Backup) error {
+	return self.server.Backups(filter, reply)
+}
+
+func (self *zmqChannelServer) RestoreBackup(name string, reply *bool) error {
+	return self.server.RestoreBackup(name, reply)
+}
+
+func (self *zmqChannelServer) Verify(args server.VerifyArgs, reply *server.VerifyReport) error {
+	return self.server.Verify(args, reply)
+}
+
+func (self *zmqChannelServer) Load(args server.MergeArgs, reply *bool) error {
+	return self.server.Load(args, reply)
+}
+
+func (self *zmqChannelServer) Changes(vault string, reply *server.MergeDiff) error {
+	return self.server.Changes(vault, reply)
+}
+
 // ----------------------------------------------------------------
 
 func ZmqChannelClient(config core.Config, startFunc server.ProxyStartFunc, proxy server.Server) ChannelClient {
 	return &zmqChannelClient{
-		config: config,
-		proxy: proxy,
+		config:    config,
+		proxy:     proxy,
 		startFunc: startFunc,
+		endpoint:  zmqEndpoint(config),
 	}
 }
 
-func (self *zmqChannelClient) Connect() (err error) {
-	host, port := networkConfig(self.config)
+func (self *zmqChannelClient) Connect() error {
+	return connectOrStart(self.connect, self.startFunc)
+}
+
+// A REQ socket is stuck if a request gets no reply; to check that the
+// server is alive, use a fresh socket and give up after a short delay.
+func (self *zmqChannelClient) connect() (err error) {
+	socket, err := self.open(zmq_connect_delay)
+	if err != nil {
+		return
+	}
+
+	self.socket = socket
+	self.session = readSession(self.config)
+	err = checkProtocol(self)
+	if err == nil {
+		// call may have replaced the socket
+		err = self.socket.SetRcvtimeo(zmq_call_delay)
+	}
+	if err == nil {
+		err = self.socket.SetSndtimeo(zmq_call_delay)
+	}
+	if err != nil {
+		if self.socket != nil {
+			self.socket.Close()
+			self.socket = nil
+		}
+		return errors.Decorated(err)
+	}
 	return
 }
 
+func (self *zmqChannelClient) open(delay time.Duration) (result *zmq.Socket, err error) {
+	socket, err := zmq.NewSocket(zmq.REQ)
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	err = socket.SetLinger(0)
+	if err == nil {
+		err = socket.SetRcvtimeo(delay)
+	}
+	if err == nil {
+		err = socket.SetSndtimeo(delay)
+	}
+	if err == nil {
+		err = socket.Connect(self.endpoint)
+	}
+	if err != nil {
+		socket.Close()
+		return nil, errors.Decorated(err)
+	}
+	return socket, nil
+}
+
+// A REQ socket that did not get its reply cannot send anymore: replace
+// it by a fresh one (nil if that fails, the client is then disconnected)
+func (self *zmqChannelClient) reset() {
+	self.socket.Close()
+	self.socket, _ = self.open(zmq_call_delay)
+}
+
 func (self *zmqChannelClient) Disconnect() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.socket != nil {
+		self.socket.SetLinger(0)
+		self.socket.Close()
+		self.socket = nil
+	}
 }
 
-func (self *zmqChannelClient) IsOpen(thenClose bool, reply *bool) (err error) {
+// Send the request and wait for the response (one at a time: REQ
+// sockets must alternate sending and receiving)
+func (self *zmqChannelClient) call(operation string, args interface{}, reply interface{}) (err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.socket == nil {
+		return errors.New("Not connected")
+	}
+
+	var request bytes.Buffer
+	enc := gob.NewEncoder(&request)
+	err = enc.Encode(&zmqRequest{Session: self.session, Operation: operation})
+	if err == nil {
+		err = enc.Encode(args)
+	}
+	if err != nil {
+		return errors.Decorated(err)
+	}
+
+	_, err = self.socket.SendBytes(request.Bytes(), 0)
+	if err != nil {
+		self.reset()
+		return errors.Decorated(err)
+	}
+	data, err := self.socket.RecvBytes(0)
+	if err != nil {
+		self.reset()
+		if zmq.AsErrno(err) == zmq.Errno(syscall.EAGAIN) {
+			return errors.Newf("The server did not reply to %s", operation)
+		}
+		return errors.Decorated(err)
+	}
+
+	var response zmqResponse
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err = dec.Decode(&response)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+	err = dec.Decode(reply)
+	if err != nil {
+		return errors.Decorated(err)
+	}
 	return
 }
 
-func (self *zmqChannelClient) Get(name string, reply *string) (err error) {
-	return
+func (self *zmqChannelClient) IsOpen(thenClose bool, reply *bool) error {
+	return self.call("IsOpen", thenClose, reply)
 }
 
-func (self *zmqChannelClient) List(filter string, reply *[]string) (err error) {
-	return
+func (self *zmqChannelClient) Get(name string, reply *string) error {
+	return self.call("Get", name, reply)
 }
 
-func (self *zmqChannelClient) Open(master string, reply *bool) (err error) {
-	return
+func (self *zmqChannelClient) List(filter string, reply *[]string) error {
+	return self.call("List", filter, reply)
 }
 
-func (self *zmqChannelClient) Merge(args server.MergeArgs, reply *bool) (err error) {
-	return
+func (self *zmqChannelClient) Open(master string, reply *bool) error {
+	return self.call("Open", master, reply)
 }
 
-func (self *zmqChannelClient) Save(force bool, reply *bool) (err error) {
-	return
+func (self *zmqChannelClient) Merge(args server.MergeArgs, reply *bool) error {
+	return self.call("Merge", args, reply)
 }
 
-func (self *zmqChannelClient) Set(args server.SetArgs, reply *string) (err error) {
-	return
+func (self *zmqChannelClient) Diff(args server.MergeArgs, reply *server.MergeDiff) error {
+	return self.call("Diff", args, reply)
 }
 
-func (self *zmqChannelClient) Unset(key string, reply *bool) (err error) {
-	return
+func (self *zmqChannelClient) Save(force bool, reply *bool) error {
+	return self.call("Save", force, reply)
 }
 
-func (self *zmqChannelClient) Stop(status int, reply *bool) (err error) {
-	return
+func (self *zmqChannelClient) Set(args server.SetArgs, reply *string) error {
+	return self.call("Set", args, reply)
 }
 
-func (self *zmqChannelClient) SetMaster(master string, reply *bool) (err error) {
-	return
+func (self *zmqChannelClient) Unset(key string, reply *bool) error {
+	return self.call("Unset", key, reply)
 }
 
-func (self *zmqChannelClient) Ping(info string, reply *string) (err error) {
-	return
+func (self *zmqChannelClient) Stop(status int, reply *bool) error {
+	return self.call("Stop", status, reply)
+}
+
+func (self *zmqChannelClient) Lock(reason string, reply *bool) error {
+	return self.call("Lock", reason, reply)
+}
+
+func (self *zmqChannelClient) SetMaster(master string, reply *bool) error {
+	return self.call("SetMaster", master, reply)
+}
+
+func (self *zmqChannelClient) Ping(info string, reply *string) error {
+	return self.call("Ping", info, reply)
+}
+
+func (self *zmqChannelClient) Info(args server.InfoArgs, reply *server.KeyInfo) error {
+	return self.call("Info", args, reply)
+}
+
+func (self *zmqChannelClient) SetField(args server.SetFieldArgs, reply *bool) error {
+	return self.call("SetField", args, reply)
+}
+
+func (self *zmqChannelClient) History(key string, reply *[]time.Time) error {
+	return self.call("History", key, reply)
+}
+
+func (self *zmqChannelClient) Rollback(args server.RollbackArgs, reply *string) error {
+	return self.call("Rollback", args, reply)
+}
+
+func (self *zmqChannelClient) Conflicts(filter string, reply *[]server.Conflict) error {
+	return self.call("Conflicts", filter, reply)
+}
+
+func (self *zmqChannelClient) Resolve(args server.ResolveArgs, reply *bool) error {
+	return self.call("Resolve", args, reply)
+}
+
+func (self *zmqChannelClient) Backups(filter string, reply *[]server.Backup) error {
+	return self.call("Backups", filter, reply)
+}
+
+func (self *zmqChannelClient) RestoreBackup(name string, reply *bool) error {
+	return self.call("RestoreBackup", name, reply)
+}
+
+func (self *zmqChannelClient) Verify(args server.VerifyArgs, reply *server.VerifyReport) error {
+	return self.call("Verify", args, reply)
+}
+
+func (self *zmqChannelClient) Load(args server.MergeArgs, reply *bool) error {
+	return self.call("Load", args, reply)
+}
+
+func (self *zmqChannelClient) Changes(vault string, reply *server.MergeDiff) error {
+	return self.call("Changes", vault, reply)
 }
@@ -0,0 +1,282 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package channel
+
+import (
+	"gate/core"
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestZmqRoundTrip(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Eval("", "connection", gomock.Any(), gomock.Any()).Return("", errors.New("no config")).AnyTimes()
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+
+	modified := time.Unix(1400000000, 0)
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Ping(server.ProtocolQuery, gomock.Any()).Do(func(info string, reply *string) {
+		*reply = server.ProtocolVersion
+	})
+	srv.EXPECT().Get("foo", gomock.Any()).Do(func(name string, reply *string) {
+		*reply = "bar"
+	})
+	srv.EXPECT().List("f", gomock.Any()).Do(func(filter string, reply *[]string) {
+		*reply = []string{"foo", "fuu"}
+	})
+	srv.EXPECT().Set(server.SetArgs{Key: "foo", Recipe: "16an"}, gomock.Any()).Do(func(args server.SetArgs, reply *string) {
+		*reply = "generated"
+	})
+	srv.EXPECT().Info(server.InfoArgs{Key: "foo"}, gomock.Any()).Do(func(args server.InfoArgs, reply *server.KeyInfo) {
+		*reply = server.KeyInfo{
+			Name:     "foo",
+			Username: "me",
+			Fields:   map[string]string{"pin": "1234"},
+			Modified: modified,
+		}
+	})
+	srv.EXPECT().History("foo", gomock.Any()).Do(func(key string, reply *[]time.Time) {
+		*reply = []time.Time{modified}
+	})
+	srv.EXPECT().Conflicts("", gomock.Any()).Do(func(filter string, reply *[]server.Conflict) {
+		*reply = []server.Conflict{{Key: "foo", LocalModified: modified, RemoteModified: modified}}
+	})
+	srv.EXPECT().Unset("foo", gomock.Any()).Return(errors.New(server.LockedMessage))
+	srv.EXPECT().Stop(0, gomock.Any()).Do(func(status int, reply *bool) {
+		*reply = true
+	})
+
+	channelServer := ZmqChannelServer(cfg, srv).(*zmqChannelServer)
+	channelServer.endpoint = "inproc://gate-test"
+	err = channelServer.Bind()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channelClient := ZmqChannelClient(cfg, func() error {
+		t.Errorf("unexpected start")
+		return nil
+	}, nil).(*zmqChannelClient)
+	channelClient.endpoint = "inproc://gate-test"
+	err = channelClient.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channelClient.Disconnect()
+
+	var value string
+	err = channelClient.Get("foo", &value)
+	if err != nil {
+		t.Error(err)
+	}
+	if value != "bar" {
+		t.Errorf("bad Get reply: '%s'", value)
+	}
+
+	var list []string
+	err = channelClient.List("f", &list)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(list) != 2 || list[0] != "foo" || list[1] != "fuu" {
+		t.Errorf("bad List reply: %v", list)
+	}
+
+	err = channelClient.Set(server.SetArgs{Key: "foo", Recipe: "16an"}, &value)
+	if err != nil {
+		t.Error(err)
+	}
+	if value != "generated" {
+		t.Errorf("bad Set reply: '%s'", value)
+	}
+
+	var info server.KeyInfo
+	err = channelClient.Info(server.InfoArgs{Key: "foo"}, &info)
+	if err != nil {
+		t.Error(err)
+	}
+	if info.Name != "foo" || info.Username != "me" || info.Fields["pin"] != "1234" || !info.Modified.Equal(modified) {
+		t.Errorf("bad Info reply: %v", info)
+	}
+
+	var history []time.Time
+	err = channelClient.History("foo", &history)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(history) != 1 || !history[0].Equal(modified) {
+		t.Errorf("bad History reply: %v", history)
+	}
+
+	var conflicts []server.Conflict
+	err = channelClient.Conflicts("", &conflicts)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Key != "foo" {
+		t.Errorf("bad Conflicts reply: %v", conflicts)
+	}
+
+	var ok bool
+	err = channelClient.Unset("foo", &ok)
+	if !server.IsLocked(err) {
+		t.Errorf("expected locked error, got %v", err)
+	}
+
+	err = channelClient.Stop(0, &ok)
+	if err != nil {
+		t.Error(err)
+	}
+	if !ok {
+		t.Errorf("bad Stop reply")
+	}
+	channelServer.Disconnect()
+}
+
+func TestZmqSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Eval("", "connection", gomock.Any(), gomock.Any()).Return("", errors.New("no config")).AnyTimes()
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Ping(server.ProtocolQuery, gomock.Any()).Do(func(info string, reply *string) {
+		*reply = server.ProtocolVersion
+	})
+
+	channelServer := ZmqChannelServer(cfg, srv).(*zmqChannelServer)
+	channelServer.endpoint = "inproc://gate-session-test"
+	err = channelServer.Bind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channelServer.Disconnect()
+
+	channelClient := ZmqChannelClient(cfg, nil, nil).(*zmqChannelClient)
+	channelClient.endpoint = "inproc://gate-session-test"
+	err = channelClient.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channelClient.Disconnect()
+
+	// without the session secret: only Ping
+	channelClient.session = ""
+	var value string
+	err = channelClient.Get("foo", &value)
+	if err == nil {
+		t.Errorf("unauthenticated Get accepted")
+	}
+}
+
+func TestZmqServerDied(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Eval("", "connection", gomock.Any(), gomock.Any()).Return("", errors.New("no config")).AnyTimes()
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+
+	srv := server.NewMockServer(ctrl)
+	srv.EXPECT().Ping(server.ProtocolQuery, gomock.Any()).Do(func(info string, reply *string) {
+		*reply = server.ProtocolVersion
+	})
+
+	channelServer := ZmqChannelServer(cfg, srv).(*zmqChannelServer)
+	channelServer.endpoint = "inproc://gate-died-test"
+	err = channelServer.Bind()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channelClient := ZmqChannelClient(cfg, nil, nil).(*zmqChannelClient)
+	channelClient.endpoint = "inproc://gate-died-test"
+	err = channelClient.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channelClient.Disconnect()
+
+	channelServer.Disconnect()
+
+	channelClient.socket.SetRcvtimeo(zmq_poll_delay)
+	var value string
+	err = channelClient.Get("foo", &value)
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+	if channelClient.socket == nil {
+		t.Errorf("expected a fresh socket")
+	}
+}
+
+func TestZmqDisconnectNotBound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	cfg.EXPECT().Eval("", "connection", gomock.Any(), gomock.Any()).Return("", errors.New("no config")).AnyTimes()
+
+	channelServer := ZmqChannelServer(cfg, server.NewMockServer(ctrl)).(*zmqChannelServer)
+	done := make(chan bool)
+	go func() {
+		channelServer.Disconnect()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Disconnect blocked")
+	}
+}
@@ -0,0 +1,219 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+// Running the server as a daemon.
+//
+// The client starts the server detached from its session, and waits
+// for the server to tell that it is ready (through a pipe). The
+// server holds a lock on its pid file, so that only one server runs
+// at a time, and logs to a rotating file in the cache directory.
+package daemon
+
+import (
+	"gate/core"
+	"gate/core/errors"
+)
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	ready_env     = "GATE_READY_FD"
+	ready_fd      = 3 // the first of exec.Cmd.ExtraFiles
+	ready_message = "ready"
+	ready_delay   = 30 * time.Second
+	stop_delay    = 10 * time.Second
+	stop_poll     = 100 * time.Millisecond
+)
+
+// The pid file, in the runtime directory
+func PidPath(config core.Config) (result string, err error) {
+	xdg, err := config.Xdg()
+	if err != nil {
+		return
+	}
+	dir, err := xdg.RuntimeDir()
+	if err != nil {
+		return
+	}
+	result = fmt.Sprintf("%s/gate.pid", dir)
+	return
+}
+
+// Start the server executable in its own session, and wait until it
+// is ready. Its standard output and error go to server.out in the
+// cache directory (only useful if the server crashes; the server logs
+// to its own file).
+func Start(config core.Config, exe string, args ...string) (err error) {
+	// a stale server may still be stopping
+	path, err := PidPath(config)
+	if err != nil {
+		return
+	}
+	err = waitStopped(path, stop_delay)
+	if err != nil {
+		return
+	}
+
+	xdg, err := config.Xdg()
+	if err != nil {
+		return
+	}
+	cache, err := xdg.CacheHome()
+	if err != nil {
+		return
+	}
+	out, err := os.OpenFile(fmt.Sprintf("%s/server.out", cache), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	defer out.Close()
+
+	ready, notify, err := os.Pipe()
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.ExtraFiles = []*os.File{notify}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", ready_env, ready_fd))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	notify.Close() // only the server writes to the pipe
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	go cmd.Wait() // reap the server if it dies before the client
+
+	status := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(ready).ReadString('\n')
+		status <- strings.TrimSpace(line)
+	}()
+
+	select {
+	case line := <-status:
+		switch {
+		case line == ready_message:
+		case line == "":
+			err = errors.Newf("The server did not start, see %s/server.out", cache)
+		default:
+			err = errors.Newf("The server did not start: %s", line)
+		}
+	case <-time.After(ready_delay):
+		err = errors.Newf("The server is not ready after %s", ready_delay)
+	}
+	return
+}
+
+// Tell the client that started the server that the server is ready,
+// or why it could not start. Does nothing if the server was not
+// started by a client.
+func NotifyReady(failure error) {
+	fd, err := strconv.Atoi(os.Getenv(ready_env))
+	if err != nil {
+		return
+	}
+	os.Unsetenv(ready_env)
+	file := os.NewFile(uintptr(fd), "ready")
+	defer file.Close()
+	if failure == nil {
+		fmt.Fprintln(file, ready_message)
+	} else {
+		if e, ok := failure.(errors.StackError); ok {
+			failure = e.Nested
+		}
+		fmt.Fprintln(file, strings.Replace(failure.Error(), "\n", " ", -1))
+	}
+}
+
+// The lock on the pid file, held while the server runs
+type PidLock struct {
+	file *os.File
+}
+
+// Lock the pid file and write the server pid in it; fails if another
+// server holds the lock.
+func LockPid(config core.Config) (result *PidLock, err error) {
+	path, err := PidPath(config)
+	if err != nil {
+		return
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		pid, _ := ioutil.ReadAll(file)
+		file.Close()
+		return nil, errors.Newf("A server is already running (pid %s)", strings.TrimSpace(string(pid)))
+	}
+	err = file.Truncate(0)
+	if err == nil {
+		_, err = fmt.Fprintf(file, "%d\n", os.Getpid())
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return nil, errors.Decorated(err)
+	}
+	result = &PidLock{file}
+	return
+}
+
+// Wait until no server holds the lock on the pid file; it is released
+// last, when the server stopped listening
+func waitStopped(path string, delay time.Duration) (err error) {
+	deadline := time.Now().Add(delay)
+	for {
+		file, e := os.Open(path)
+		if e != nil {
+			// no pid file: no server
+			return nil
+		}
+		e = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if e == nil {
+			file.Close()
+			return nil
+		}
+		pid, _ := ioutil.ReadAll(file)
+		file.Close()
+		if time.Now().After(deadline) {
+			return errors.Newf("A server is still running (pid %s)", strings.TrimSpace(string(pid)))
+		}
+		time.Sleep(stop_poll)
+	}
+}
+
+// Remove the pid file and release the lock
+func (self *PidLock) Release() {
+	os.Remove(self.file.Name())
+	self.file.Close()
+}
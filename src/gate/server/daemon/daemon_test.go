@@ -0,0 +1,191 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"gate/core"
+	"gate/core/errors"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestConfig(t *testing.T, ctrl *gomock.Controller) (cfg *core.MockConfig, dir string) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+	xdg.EXPECT().CacheHome().Return(dir, nil).AnyTimes()
+	return
+}
+
+// Not a real test: the "server" started by TestStart
+func TestHelperServer(t *testing.T) {
+	switch os.Getenv("GATE_TEST_SERVER") {
+	case "ready":
+		NotifyReady(nil)
+	case "fail":
+		NotifyReady(errors.New("cannot bind"))
+	case "crash":
+		os.Exit(2)
+	default:
+		return
+	}
+	os.Exit(0)
+}
+
+func startHelper(cfg core.Config, mode string) error {
+	os.Setenv("GATE_TEST_SERVER", mode)
+	defer os.Unsetenv("GATE_TEST_SERVER")
+	return Start(cfg, os.Args[0], "-test.run=TestHelperServer")
+}
+
+func TestStart(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg, dir := newTestConfig(t, ctrl)
+	defer os.RemoveAll(dir)
+
+	err := startHelper(cfg, "ready")
+	if err != nil {
+		t.Errorf("ready server: %s", err)
+	}
+
+	err = startHelper(cfg, "fail")
+	if err == nil || !strings.Contains(err.Error(), "cannot bind") {
+		t.Errorf("failed server: bad error %v", err)
+	}
+
+	err = startHelper(cfg, "crash")
+	if err == nil || !strings.Contains(err.Error(), "server.out") {
+		t.Errorf("crashed server: bad error %v", err)
+	}
+}
+
+func TestLockPid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg, dir := newTestConfig(t, ctrl)
+	defer os.RemoveAll(dir)
+
+	lock, err := LockPid(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(dir + "/gate.pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := strings.TrimSpace(string(data))
+
+	_, err = LockPid(cfg)
+	if err == nil {
+		t.Errorf("two servers locked the pid file")
+	} else if !strings.Contains(err.Error(), pid) {
+		t.Errorf("the error does not tell the pid: %s", err)
+	}
+
+	lock.Release()
+	lock, err = LockPid(cfg)
+	if err != nil {
+		t.Fatalf("pid file still locked: %s", err)
+	}
+	lock.Release()
+}
+
+func TestWaitStopped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg, dir := newTestConfig(t, ctrl)
+	defer os.RemoveAll(dir)
+
+	path := dir + "/gate.pid"
+	err := waitStopped(path, time.Second)
+	if err != nil {
+		t.Errorf("no server: %s", err)
+	}
+
+	lock, err := LockPid(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = waitStopped(path, 200*time.Millisecond)
+	if err == nil {
+		t.Errorf("expected error: the server is running")
+	}
+
+	// the stale server stops a bit later
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		lock.Release()
+	}()
+	err = waitStopped(path, 5*time.Second)
+	if err != nil {
+		t.Errorf("stopped server: %s", err)
+	}
+}
+
+func TestRotatingLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	log := &rotatingLog{
+		path:    dir + "/server.log",
+		maxSize: 10,
+		count:   2,
+	}
+	err = log.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = log.Write([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	for file, expected := range map[string]string{
+		"server.log":   "fourth\n",
+		"server.log.1": "third\n",
+		"server.log.2": "second\n",
+	} {
+		data, err := ioutil.ReadFile(dir + "/" + file)
+		if err != nil {
+			t.Error(err)
+		} else if string(data) != expected {
+			t.Errorf("%s: bad content '%s'", file, data)
+		}
+	}
+	_, err = os.Stat(dir + "/server.log.3")
+	if err == nil {
+		t.Errorf("too many logs kept")
+	}
+}
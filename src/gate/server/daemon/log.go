@@ -0,0 +1,114 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+// The server log: server.log in the cache directory, rotated when it
+// becomes too big (server.log.1 is the most recent old log)
+
+import (
+	"gate/core"
+	"gate/core/errors"
+)
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	log_max_size = 1024 * 1024
+	log_count    = 5
+)
+
+type rotatingLog struct {
+	lock    sync.Mutex
+	path    string
+	maxSize int64
+	count   int
+	file    *os.File
+	size    int64
+}
+
+// Open the server log (to give to log.SetOutput)
+func OpenLog(config core.Config) (result io.WriteCloser, err error) {
+	xdg, err := config.Xdg()
+	if err != nil {
+		return
+	}
+	cache, err := xdg.CacheHome()
+	if err != nil {
+		return
+	}
+	rotating := &rotatingLog{
+		path:    fmt.Sprintf("%s/server.log", cache),
+		maxSize: log_max_size,
+		count:   log_count,
+	}
+	err = rotating.open()
+	if err == nil {
+		result = rotating
+	}
+	return
+}
+
+func (self *rotatingLog) open() (err error) {
+	self.file, err = os.OpenFile(self.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	info, err := self.file.Stat()
+	if err != nil {
+		self.file.Close()
+		return errors.Decorated(err)
+	}
+	self.size = info.Size()
+	return
+}
+
+func (self *rotatingLog) rotate() (err error) {
+	self.file.Close()
+	for i := self.count - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", self.path, i), fmt.Sprintf("%s.%d", self.path, i+1))
+	}
+	err = os.Rename(self.path, fmt.Sprintf("%s.1", self.path))
+	e := self.open() // keep logging, even if the rotation failed
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	return e
+}
+
+func (self *rotatingLog) Write(data []byte) (n int, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.size > 0 && self.size+int64(len(data)) > self.maxSize {
+		err = self.rotate()
+		if err != nil {
+			return
+		}
+	}
+	n, err = self.file.Write(data)
+	self.size += int64(n)
+	return
+}
+
+func (self *rotatingLog) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.file.Close()
+}
@@ -0,0 +1,140 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+// Native vault encryption: scrypt key derivation and AES-256-GCM
+// authenticated encryption. The scrypt parameters are stored in the
+// vault header.
+//
+// Stream layout: salt (16 bytes), nonce (12 bytes), sealed data.
+
+import (
+	"gate/core/errors"
+)
+
+import (
+	"code.google.com/p/go.crypto/scrypt"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"strconv"
+)
+
+type aes_cipher struct {
+	n int
+	r int
+	p int
+}
+
+var _ Cipher = &aes_cipher{}
+
+const (
+	aes_salt_size = 16
+	aes_key_size  = 32
+)
+
+func newAesCipher(parameters map[string]string) (result Cipher, err error) {
+	c := &aes_cipher{
+		n: 1 << 15,
+		r: 8,
+		p: 1,
+	}
+	if parameters != nil {
+		kdf := parameters["kdf"]
+		if kdf != "scrypt" {
+			return nil, errors.Newf("Unknown key derivation function: %s", kdf)
+		}
+		for name, value := range map[string]*int{"n": &c.n, "r": &c.r, "p": &c.p} {
+			*value, err = strconv.Atoi(parameters[name])
+			if err != nil {
+				return nil, errors.Newf("Invalid scrypt parameter %s: '%s'", name, parameters[name])
+			}
+		}
+	}
+	result = c
+	return
+}
+
+func (self *aes_cipher) Name() string {
+	return "aes-256-gcm"
+}
+
+func (self *aes_cipher) Parameters() map[string]string {
+	return map[string]string{
+		"kdf": "scrypt",
+		"n":   strconv.Itoa(self.n),
+		"r":   strconv.Itoa(self.r),
+		"p":   strconv.Itoa(self.p),
+	}
+}
+
+func (self *aes_cipher) aead(master string, salt []byte) (result cipher.AEAD, err error) {
+	key, err := scrypt.Key([]byte(master), salt, self.n, self.r, self.p, aes_key_size)
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	result, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	return
+}
+
+func (self *aes_cipher) Decrypt(data []byte, master string) (result []byte, err error) {
+	if len(data) < aes_salt_size {
+		return nil, errors.New("Invalid vault: truncated data")
+	}
+	salt := data[:aes_salt_size]
+	aead, err := self.aead(master, salt)
+	if err != nil {
+		return
+	}
+	data = data[aes_salt_size:]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("Invalid vault: truncated data")
+	}
+	nonce := data[:aead.NonceSize()]
+	result, err = aead.Open(nil, nonce, data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("Could not decrypt vault: bad master or corrupted vault")
+	}
+	return
+}
+
+func (self *aes_cipher) Encrypt(data []byte, master string) (result []byte, err error) {
+	salt := make([]byte, aes_salt_size)
+	_, err = io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	aead, err := self.aead(master, salt)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	result = append(salt, nonce...)
+	result = aead.Seal(result, nonce, data, nil)
+	return
+}
@@ -0,0 +1,161 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+// Atomic file writes: the data is written to a temporary file in the
+// same directory, which replaces the target file only when everything
+// was written and synced.
+
+import (
+	"gate/core/errors"
+)
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type atomicFile struct {
+	file *os.File
+	path string
+	hash hash.Hash
+	err  error
+}
+
+// Create a temporary file that will replace the file at path when
+// closed (unless a write failed)
+func createAtomic(path string) (io.WriteCloser, error) {
+	return newAtomic(path)
+}
+
+func newAtomic(path string) (result *atomicFile, err error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	err = file.Chmod(0600)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, errors.Decorated(err)
+	}
+	result = &atomicFile{
+		file: file,
+		path: path,
+		hash: sha256.New(),
+	}
+	return
+}
+
+// Make Close remove the temporary file instead of replacing the target
+// (e.g. the data could not be read completely)
+func (self *atomicFile) abort(err error) {
+	if self.err == nil {
+		self.err = err
+	}
+}
+
+func (self *atomicFile) Write(data []byte) (n int, err error) {
+	if self.err != nil {
+		return 0, self.err
+	}
+	n, err = self.file.Write(data)
+	self.hash.Write(data[:n])
+	if err != nil {
+		self.err = err
+	}
+	return
+}
+
+// Check that the file really contains what was written
+func (self *atomicFile) check() error {
+	file, err := os.Open(self.file.Name())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), self.hash.Sum(nil)) {
+		return errors.Newf("%s: written data could not be read back", self.file.Name())
+	}
+	return nil
+}
+
+func (self *atomicFile) Close() (err error) {
+	err = self.err
+	if err == nil {
+		err = self.file.Sync()
+	}
+	e := self.file.Close()
+	if err == nil {
+		err = e
+	}
+	if err == nil {
+		err = self.check()
+	}
+	if err == nil {
+		err = os.Rename(self.file.Name(), self.path)
+	}
+	if err != nil {
+		os.Remove(self.file.Name())
+		return errors.Decorated(err)
+	}
+
+	// make the rename itself durable
+	dir, e := os.Open(filepath.Dir(self.path))
+	if e == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return
+}
+
+// Atomically replace the file at path by a copy of the file at source
+func copyAtomic(source, path string) (err error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	defer in.Close()
+	return writeAtomic(in, path)
+}
+
+// Atomically replace the file at path by all the data read from in;
+// the file is left untouched if in could not be read to the end
+func writeAtomic(in io.Reader, path string) (err error) {
+	out, err := newAtomic(path)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.abort(err)
+	}
+	e := out.Close()
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	return e
+}
@@ -0,0 +1,184 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+// Vault backups: before being replaced, the vault file is copied to
+// the backups directory (in the XDG data home). Only the most recent
+// backups are kept.
+
+import (
+	"gate/core"
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	backup_prefix   = "vault-"
+	backup_format   = "20060102-150405.000000000"
+	default_backups = 5
+)
+
+type backups struct {
+	dir  string
+	keep int
+	// set while the vault is saved right before being replaced: it is
+	// backed up once, after the save
+	paused bool
+}
+
+// The backups of the vault, kept in the XDG data home. The number of
+// backups is read from the [server] backups key (0 to disable them).
+func newBackups(config core.Config) (result *backups, err error) {
+	xdg, err := config.Xdg()
+	if err != nil {
+		return
+	}
+	data_home, err := xdg.DataHome()
+	if err != nil {
+		return
+	}
+	keep := default_backups
+	value, e := config.Eval("", "server", "backups", os.Getenv)
+	if e == nil && value != "" {
+		keep, err = strconv.Atoi(value)
+		if err != nil || keep < 0 {
+			return nil, errors.Newf("Invalid number for [server] backups: '%s'", value)
+		}
+	}
+	result = &backups{
+		dir:  fmt.Sprintf("%s/backups", data_home),
+		keep: keep,
+	}
+	return
+}
+
+// Copy the file to a new backup (if it exists), and remove the oldest
+// backups
+func (self *backups) backup(path string) (err error) {
+	if self.keep == 0 || self.paused {
+		return
+	}
+	_, err = os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Decorated(err)
+	}
+	err = os.MkdirAll(self.dir, 0700)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	name := backup_prefix + time.Now().UTC().Format(backup_format)
+	err = copyAtomic(path, filepath.Join(self.dir, name))
+	if err != nil {
+		return
+	}
+	return self.prune()
+}
+
+func (self *backups) names() (result []string, err error) {
+	files, err := ioutil.ReadDir(self.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Decorated(err)
+	}
+	for _, file := range files {
+		if file.Mode().IsRegular() && strings.HasPrefix(file.Name(), backup_prefix) {
+			result = append(result, file.Name())
+		}
+	}
+	// the timestamps sort chronologically; most recent first
+	sort.Sort(sort.Reverse(sort.StringSlice(result)))
+	return
+}
+
+func (self *backups) prune() (err error) {
+	names, err := self.names()
+	if err != nil {
+		return
+	}
+	for i := self.keep; i < len(names); i++ {
+		err = os.Remove(filepath.Join(self.dir, names[i]))
+		if err != nil {
+			return errors.Decorated(err)
+		}
+	}
+	return
+}
+
+// The existing backups, most recent first
+func (self *backups) list() (result []server.Backup, err error) {
+	names, err := self.names()
+	if err != nil {
+		return
+	}
+	result = make([]server.Backup, 0, len(names))
+	for _, name := range names {
+		info, e := os.Stat(filepath.Join(self.dir, name))
+		if e != nil {
+			continue
+		}
+		date, e := time.Parse(backup_format, strings.TrimPrefix(name, backup_prefix))
+		if e != nil {
+			date = info.ModTime()
+		}
+		result = append(result, server.Backup{
+			Name: name,
+			Date: date,
+			Size: info.Size(),
+		})
+	}
+	return
+}
+
+// Replace the file by the given backup; the file is backed up first
+func (self *backups) restore(name string, path string) (err error) {
+	if name == "" || name != filepath.Base(name) || !strings.HasPrefix(name, backup_prefix) {
+		return errors.Newf("Invalid backup: %s", name)
+	}
+	source := filepath.Join(self.dir, name)
+	_, err = os.Stat(source)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Newf("Unknown backup: %s", name)
+		}
+		return errors.Decorated(err)
+	}
+	if self.keep > 0 {
+		// keep the restored backup even if it is the oldest one
+		self.keep++
+		err = self.backup(path)
+		self.keep--
+		if err != nil {
+			return
+		}
+	}
+	return copyAtomic(source, path)
+}
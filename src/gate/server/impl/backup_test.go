@@ -0,0 +1,338 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func checkNoTempFiles(t *testing.T, dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, ".vault.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("temporary files left: %v", files)
+	}
+}
+
+func TestAtomicWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vault")
+	err = ioutil.WriteFile(path, []byte("old"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := createAtomic(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = out.Write([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not replaced until closed
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old" {
+		t.Errorf("file replaced before close: '%s'", data)
+	}
+
+	err = out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("bad file content: '%s'", data)
+	}
+	checkNoTempFiles(t, dir)
+}
+
+func TestAtomicWriteFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// cannot rename a file over a non-empty directory
+	path := filepath.Join(dir, "vault")
+	err = os.MkdirAll(filepath.Join(path, "keep"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := createAtomic(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = out.Write([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = out.Close()
+	if err == nil {
+		t.Error("expected error")
+	}
+	_, err = os.Stat(filepath.Join(path, "keep"))
+	if err != nil {
+		t.Errorf("target changed: %s", err)
+	}
+	checkNoTempFiles(t, dir)
+}
+
+type failingReader struct {
+	data []byte
+}
+
+func (self *failingReader) Read(p []byte) (n int, err error) {
+	if len(self.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n = copy(p, self.data)
+	self.data = self.data[n:]
+	return
+}
+
+func TestAtomicCopyReadFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vault")
+	err = ioutil.WriteFile(path, []byte("old"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeAtomic(&failingReader{[]byte("trunc")}, path)
+	if err == nil {
+		t.Error("expected error")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old" {
+		t.Errorf("file replaced by a partial copy: '%s'", data)
+	}
+	checkNoTempFiles(t, dir)
+}
+
+func TestBackupRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vault")
+	b := &backups{dir: filepath.Join(dir, "backups"), keep: 3}
+
+	// nothing to back up yet
+	err = b.backup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"1", "2", "3", "4", "5"} {
+		err = ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.backup(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := b.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("bad backups count: %d", len(list))
+	}
+	for i, expected := range []string{"5", "4", "3"} {
+		data, err := ioutil.ReadFile(filepath.Join(b.dir, list[i].Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("bad backup %d: '%s' instead of '%s'", i+1, data, expected)
+		}
+		if list[i].Size != 1 || list[i].Date.IsZero() {
+			t.Errorf("bad backup %d: %v", i+1, list[i])
+		}
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vault")
+	b := &backups{dir: filepath.Join(dir, "backups"), keep: 2}
+
+	for _, content := range []string{"1", "2", "3"} {
+		err = ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.backup(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(path, []byte("current"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := b.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldest := list[len(list)-1].Name
+	err = b.restore(oldest, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "2" {
+		t.Errorf("bad restored content: '%s'", data)
+	}
+
+	// the replaced vault is backed up too
+	list, err = b.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(filepath.Join(b.dir, list[0].Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "current" {
+		t.Errorf("bad backup of the replaced vault: '%s'", data)
+	}
+
+	for _, name := range []string{"", "../vault", "unknown"} {
+		err = b.restore(name, path)
+		if err == nil {
+			t.Errorf("expected error for '%s'", name)
+		}
+	}
+}
+
+func TestSaveVault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vault")
+	b := &backups{dir: filepath.Join(dir, "backups"), keep: 5}
+
+	v := newVault(path, b)
+	err = v.Open("secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pass := range []string{"bar", "baz"} {
+		err = v.SetPass("foo", pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = v.Save(false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkNoTempFiles(t, dir)
+
+	// the first save had nothing to back up
+	list, err := b.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("bad backups count: %d", len(list))
+	}
+
+	for file, expected := range map[string]string{path: "baz", filepath.Join(b.dir, list[0].Name): "bar"} {
+		v = newVault(file, nil)
+		err = v.Open("secret", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := v.Item("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.Password() != expected {
+			t.Errorf("%s: bad password '%s' instead of '%s'", file, k.Password(), expected)
+		}
+	}
+}
+
+func TestSaveCheck(t *testing.T) {
+	v := newTestVault(t, map[string]string{"foo": "bar"}).(*vault)
+	content := &bytes.Buffer{}
+	data, err := v.cipher.Encrypt([]byte("plain"), v.master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeVaultFile(content, newVaultHeader(v.cipher, v.records), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.check(content.Bytes(), []byte("plain"))
+	if err != nil {
+		t.Error(err)
+	}
+	err = v.check(content.Bytes(), []byte("other"))
+	if err == nil {
+		t.Error("expected error")
+	}
+}
@@ -17,6 +17,10 @@ package impl
 
 // Blowfish vault keys (now considered weak)
 
+import (
+	"gate/core/errors"
+)
+
 import (
 	"bytes"
 	"fmt"
@@ -28,84 +32,70 @@ import (
 var _ Key = &bf_key{}
 
 type bf_key struct {
-	name	 string
-	pass	 string
-	delcount int64
-	addcount int64
-}
-
-func (self *bf_key) Name() string {
-	return self.name
-}
-
-func (self *bf_key) Password() string {
-	if self.IsDeleted() {
-		return ""
-	}
-	return self.pass
-}
-
-func (self *bf_key) IsDeleted() bool {
-	return self.delcount > self.addcount
-}
-
-func (self *bf_key) Delete() {
-	self.delcount = self.addcount + 1
+	key_data
 }
 
 func (self *bf_key) Encoded() string {
-	return fmt.Sprintf("%s:%d:%d:%s\n", self.name, self.addcount, self.delcount, self.pass)
+	return fmt.Sprintf("%s:%d:%d:%s\n%s", self.name, self.addcount, self.delcount, self.pass, self.encodedMeta())
 }
 
-func (self *bf_key) Merge(other Key) {
-	okey := other.(*bf_key)
-
-	if self.delcount < okey.delcount {
-		self.delcount = okey.delcount
-	}
-	if self.addcount < okey.addcount {
-		self.pass = okey.pass
-		self.addcount = okey.addcount
-	}
-}
-
-func (self *bf_key) SetPassword(pass string) {
-	self.pass = pass
-	self.addcount = self.addcount + 1
-}
-
-var bf_decoder = regexp.MustCompile("(?P<name>[^:]+):(?P<add>[0-9]+):(?P<del>[0-9]+):(?P<pass>.*)")
+var bf_decoder = regexp.MustCompile("^(?P<name>[^:]+):(?P<add>[0-9]+):(?P<del>[0-9]+):(?P<pass>.*)")
 
 func bf_decode(v *vault, out io.ReadCloser, barrier chan error) {
 	buffer := bytes.NewBuffer(make([]byte, 0, 4096))
 	_, err := buffer.ReadFrom(out)
 	if err != nil {
-		barrier <- err
+		barrier <- errors.Decorated(err)
+		barrier <- io.EOF
 		return
 	}
 	data := string(buffer.Bytes())
 
-	for _, line := range strings.Split(data, "\n") {
-		if line != "" {
-			linematch := bf_decoder.FindSubmatchIndex([]byte(line))
+	var last Key
+	for i, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			if last == nil {
+				barrier <- recordError(i+1, "metadata without key")
+				continue
+			}
+			err = last.keyData().decodeMeta(line[1:])
+			if err != nil {
+				barrier <- recordError(i+1, "invalid metadata for key %s", last.Name())
+			}
+		} else if line != "" {
+			last = nil
+			linematch := bf_decoder.FindStringSubmatchIndex(line)
+			if linematch == nil {
+				barrier <- recordError(i+1, "malformed record")
+				continue
+			}
 			name := decode_group(bf_decoder, line, "name", linematch)
 			pass := decode_group(bf_decoder, line, "pass", linematch)
 			delcount, err := decode_group_int(bf_decoder, line, "del", linematch)
 			if err != nil {
-				barrier <- err
+				barrier <- recordError(i+1, "invalid counter for key %s", name)
 				continue
 			}
 			addcount, err := decode_group_int(bf_decoder, line, "add", linematch)
 			if err != nil {
-				barrier <- err
+				barrier <- recordError(i+1, "invalid counter for key %s", name)
 				continue
 			}
 
 			k := &bf_key{
-				name:	  name,
-				pass:	  pass,
-				delcount: delcount,
-				addcount: addcount,
+				key_data{
+					name:     name,
+					pass:     pass,
+					delcount: delcount,
+					addcount: addcount,
+				},
+			}
+			// a rejected key still gets its metadata, but is not kept
+			last = k
+			err = checkRecord(v.data, i+1, k)
+			if err != nil {
+				barrier <- err
+				continue
 			}
 			v.data[name] = k
 		}
@@ -115,10 +105,14 @@ func bf_decode(v *vault, out io.ReadCloser, barrier chan error) {
 }
 
 func bf_newkey(name string, pass string) Key {
-	return &bf_key{
-		name:	  name,
-		pass:	  pass,
-		delcount: 0,
-		addcount: 1,
+	k := &bf_key{
+		key_data{
+			name:     name,
+			pass:     pass,
+			delcount: 0,
+			addcount: 1,
+		},
 	}
+	k.touch()
+	return k
 }
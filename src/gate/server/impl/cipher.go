@@ -0,0 +1,46 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+// Vault encryption
+
+// A vault cipher. The data are the whole (binary) vault stream.
+type Cipher interface {
+	// The cipher name
+	Name() string
+	// The cipher parameters, stored in the vault header
+	Parameters() map[string]string
+	// Decrypt the data using the given master phrase
+	Decrypt(data []byte, master string) ([]byte, error)
+	// Encrypt the data using the given master phrase
+	Encrypt(data []byte, master string) ([]byte, error)
+}
+
+// The cipher used to write vaults
+const default_cipher = "aes-256-gcm"
+
+// Return the cipher known by the given name. The parameters are the
+// ones read from the vault header; they may be nil, in which case
+// default values are used.
+func NewCipher(name string, parameters map[string]string) (result Cipher, err error) {
+	switch name {
+	case "aes-256-gcm":
+		result, err = newAesCipher(parameters)
+	default:
+		result, err = newOpensslCipher(name)
+	}
+	return
+}
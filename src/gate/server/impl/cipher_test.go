@@ -0,0 +1,166 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+import (
+	"gate/core"
+)
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/golang/mock/gomock"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// printf 'foo:1:0:bar\nbaz:2:0:qux\n' | openssl bf -a -pass pass:secret -md md5
+const legacy_bf_md5 = "U2FsdGVkX18aCLwcgD/zHygsFZAcOkNbFKKVs724qmiXz4ynJnv6mVjGfHNQeBpm\n"
+
+// printf 'foo:1:0:bar\nbaz:2:0:qux\n' | openssl bf -a -pass pass:secret -md sha256
+const legacy_bf_sha256 = "U2FsdGVkX1+b/tCj1rEfIJV0PoXYQPr/v36FXmVLyCnBPVs2xzHH+Rkaj6jBsABp\n"
+
+// printf 'foo:1:0:bar\n' | openssl aes-256-cbc -a -pass pass:secret -md sha256
+const legacy_aes_sha256 = "U2FsdGVkX1+OiOtN/uP936U2+jVwI1RVmm1AaYPLDos=\n"
+
+func checkLegacy(t *testing.T, name string, vault string, expected string) {
+	data, err := base64.StdEncoding.DecodeString(vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher, err := newOpensslCipher(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := cipher.Decrypt(data, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != expected {
+		t.Errorf("bad decryption: '%s'", string(plain))
+	}
+	_, err = cipher.Decrypt(data, "wrong")
+	if err == nil {
+		t.Errorf("decrypted using a wrong master")
+	}
+}
+
+func TestLegacyBlowfishMd5(t *testing.T) {
+	checkLegacy(t, "bf", legacy_bf_md5, "foo:1:0:bar\nbaz:2:0:qux\n")
+}
+
+func TestLegacyBlowfishSha256(t *testing.T) {
+	checkLegacy(t, "bf", legacy_bf_sha256, "foo:1:0:bar\nbaz:2:0:qux\n")
+}
+
+func TestLegacyAes(t *testing.T) {
+	checkLegacy(t, "aes-256-cbc", legacy_aes_sha256, "foo:1:0:bar\n")
+}
+
+func TestAesRoundTrip(t *testing.T) {
+	cipher, err := NewCipher("aes-256-gcm", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := cipher.Encrypt([]byte("foo:1:0:bar\n"), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("bar")) {
+		t.Errorf("cleartext found in encrypted data")
+	}
+	plain, err := cipher.Decrypt(data, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != "foo:1:0:bar\n" {
+		t.Errorf("bad decryption: '%s'", string(plain))
+	}
+	_, err = cipher.Decrypt(data, "wrong")
+	if err == nil {
+		t.Errorf("decrypted using a wrong master")
+	}
+	data[len(data)-1] ^= 1
+	_, err = cipher.Decrypt(data, "secret")
+	if err == nil {
+		t.Errorf("decrypted tampered data")
+	}
+}
+
+type memfile struct {
+	*bytes.Buffer
+}
+
+func (self memfile) Close() error {
+	return nil
+}
+
+func memVault(content string) (result Vault, file *bytes.Buffer) {
+	file = bytes.NewBufferString(content)
+	in := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(file.Bytes())), nil
+	}
+	out := func() (io.WriteCloser, error) {
+		file.Reset()
+		return memfile{file}, nil
+	}
+	result = NewVault(in, out)
+	return
+}
+
+func TestVaultLegacyUpgrade(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	cfg.EXPECT().Eval("", "vault", "openssl.cipher", gomock.Any()).Return("bf", nil)
+
+	v, file := memVault(legacy_bf_sha256)
+	err := v.Open("secret", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := v.Item("baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Password() != "qux" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+
+	err = v.Save(false, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(file.String(), "GATE/1 cipher=aes-256-gcm keys=bf kdf=scrypt ") {
+		t.Fatalf("legacy vault not upgraded: %s", file.String())
+	}
+
+	v2, _ := memVault(file.String())
+	err = v2.Open("secret", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err = v2.Item("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Password() != "bar" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+}
@@ -0,0 +1,191 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+// Vault file format
+//
+// The vault file starts with a header line:
+//
+//     GATE/<version> cipher=<cipher> keys=<records> [<parameter>=<value>...]
+//
+// followed by the encrypted data, in base64 split in lines (like
+// openssl -a). The extra parameters are the cipher ones (e.g. key
+// derivation function).
+//
+// Files without header are older vaults: either written by openssl,
+// or by the first versions of the native cipher. Their records format
+// is "bf".
+
+import (
+	"gate/core"
+	"gate/core/errors"
+)
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	vault_magic   = "GATE/"
+	vault_version = 1
+)
+
+type vault_header struct {
+	version    int
+	cipher     string
+	records    string
+	parameters map[string]string
+}
+
+func newVaultHeader(cipher Cipher, records string) *vault_header {
+	return &vault_header{
+		version:    vault_version,
+		cipher:     cipher.Name(),
+		records:    records,
+		parameters: cipher.Parameters(),
+	}
+}
+
+func (self *vault_header) String() string {
+	fields := []string{
+		fmt.Sprintf("%s%d", vault_magic, self.version),
+		fmt.Sprintf("cipher=%s", self.cipher),
+		fmt.Sprintf("keys=%s", self.records),
+	}
+	names := make([]string, 0, len(self.parameters))
+	for name := range self.parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, fmt.Sprintf("%s=%s", name, self.parameters[name]))
+	}
+	return strings.Join(fields, " ")
+}
+
+func parseVaultHeader(line string) (result *vault_header, err error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], vault_magic) {
+		return nil, errors.New("Invalid vault header")
+	}
+	version, err := strconv.Atoi(fields[0][len(vault_magic):])
+	if err != nil {
+		return nil, errors.Newf("Invalid vault version: %s", fields[0])
+	}
+	if version > vault_version {
+		return nil, errors.Newf("Unsupported vault version %d, please upgrade Gate", version)
+	}
+
+	result = &vault_header{
+		version:    version,
+		parameters: make(map[string]string),
+	}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Newf("Invalid vault header field: %s", field)
+		}
+		switch kv[0] {
+		case "cipher":
+			result.cipher = kv[1]
+		case "keys":
+			result.records = kv[1]
+		default:
+			result.parameters[kv[0]] = kv[1]
+		}
+	}
+	if result.cipher == "" || result.records == "" {
+		return nil, errors.New("Incomplete vault header")
+	}
+	return
+}
+
+// Read the vault file: returns its header and the (still encrypted) data.
+func readVaultFile(in io.Reader, config core.Config) (header *vault_header, data []byte, err error) {
+	buffer := &bytes.Buffer{}
+	_, err = buffer.ReadFrom(in)
+	if err != nil {
+		return nil, nil, errors.Decorated(err)
+	}
+	content := buffer.String()
+
+	if strings.HasPrefix(content, vault_magic) {
+		lines := strings.SplitN(content, "\n", 2)
+		header, err = parseVaultHeader(lines[0])
+		if err != nil {
+			return
+		}
+		if len(lines) > 1 {
+			content = lines[1]
+		} else {
+			content = ""
+		}
+	}
+
+	// the decoder ignores newlines
+	data, err = base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, nil, errors.Decorated(err)
+	}
+
+	if header == nil {
+		header = &vault_header{
+			records: "bf",
+		}
+		if bytes.HasPrefix(data, openssl_magic) {
+			header.cipher, err = config.Eval("", "vault", "openssl.cipher", os.Getenv)
+			if err != nil || header.cipher == "" {
+				header.cipher = "bf"
+				err = nil
+			}
+		} else {
+			header.cipher = default_cipher
+		}
+	}
+	return
+}
+
+// Vaults are base64 streams, split in lines (like openssl -a)
+const armor_width = 64
+
+// Write the vault file: its header and the encrypted data.
+func writeVaultFile(out io.Writer, header *vault_header, data []byte) (err error) {
+	_, err = io.WriteString(out, header.String()+"\n")
+	if err != nil {
+		return errors.Decorated(err)
+	}
+
+	armored := base64.StdEncoding.EncodeToString(data)
+	for len(armored) > 0 {
+		n := armor_width
+		if n > len(armored) {
+			n = len(armored)
+		}
+		_, err = io.WriteString(out, armored[:n]+"\n")
+		if err != nil {
+			return errors.Decorated(err)
+		}
+		armored = armored[n:]
+	}
+	return
+}
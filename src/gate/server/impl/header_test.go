@@ -0,0 +1,139 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+import (
+	"gate/core"
+)
+
+import (
+	"github.com/golang/mock/gomock"
+	"strings"
+	"testing"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	cipher, err := NewCipher("aes-256-gcm", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := newVaultHeader(cipher, "scrypt")
+	line := header.String()
+	if line != "GATE/1 cipher=aes-256-gcm keys=scrypt kdf=scrypt n=32768 p=1 r=8" {
+		t.Errorf("bad header: %s", line)
+	}
+	parsed, err := parseVaultHeader(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.version != 1 || parsed.cipher != "aes-256-gcm" || parsed.records != "scrypt" || parsed.parameters["n"] != "32768" {
+		t.Errorf("bad parsed header: %v", parsed)
+	}
+}
+
+func TestHeaderFutureVersion(t *testing.T) {
+	_, err := parseVaultHeader("GATE/99 cipher=foo keys=bar")
+	if err == nil {
+		t.Errorf("accepted a future version")
+	}
+}
+
+func TestNewVaultUsesScryptKeys(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+
+	v, file := memVault("")
+	err := v.(*vault).create("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.SetPass("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.Save(false, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(file.String(), "GATE/1 cipher=aes-256-gcm keys=scrypt ") {
+		t.Fatalf("bad vault header: %s", file.String())
+	}
+
+	v2, _ := memVault(file.String())
+	err = v2.Open("secret", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := v2.Item("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.(*scrypt_key); !ok {
+		t.Errorf("bad key type: %T", k)
+	}
+	if k.Password() != "bar" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+}
+
+func TestMergeLegacyIntoScrypt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	cfg.EXPECT().Eval("", "vault", "openssl.cipher", gomock.Any()).Return("bf", nil)
+
+	v, _ := memVault("")
+	err := v.(*vault).create("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.SetPass("foo", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy, _ := memVault(legacy_bf_md5)
+	err = legacy.Open("secret", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = v.Merge(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := v.Item("baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.(*scrypt_key); !ok {
+		t.Errorf("bad key type: %T", k)
+	}
+	if k.Password() != "qux" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+	k, err = v.Item("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Password() != "new" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+}
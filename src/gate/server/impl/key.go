@@ -21,8 +21,12 @@ import (
 
 import (
 	"fmt"
+	"io"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // A vault key
@@ -34,6 +38,397 @@ type Key interface {
 	Encoded() string
 	Merge(other Key)
 	SetPassword(pass string)
+
+	// Merge conflicts: true if both keys changed the password since
+	// their last common version
+	Conflicts(other Key) bool
+	// Resolve a conflict, keeping either this password or the other
+	Resolve(other Key, useOther bool)
+
+	// Password history: the dates when the previous passwords were
+	// replaced, most recent first
+	History() []time.Time
+	// Restore the n-th previous password (1 is the most recent)
+	Rollback(n int) error
+
+	// Metadata
+	Field(name string) string
+	Fields() map[string]string
+	SetField(name string, value string) error
+	Created() time.Time
+	Modified() time.Time
+
+	keyData() *key_data
+}
+
+// The well-known metadata fields
+const (
+	Username = "username"
+	Url      = "url"
+	Notes    = "notes"
+)
+
+// The data common to all the key formats
+type key_data struct {
+	name     string
+	pass     string
+	delcount int64
+	addcount int64
+	fields   map[string]string
+	created  time.Time
+	modified time.Time
+	history  []password_version
+}
+
+// A previous password, and the date it was replaced
+type password_version struct {
+	pass string
+	date time.Time
+}
+
+// The maximum number of previous passwords kept by each key
+const history_size = 10
+
+func (self *key_data) keyData() *key_data {
+	return self
+}
+
+func (self *key_data) Name() string {
+	return self.name
+}
+
+func (self *key_data) Password() string {
+	if self.IsDeleted() {
+		return ""
+	}
+	return self.pass
+}
+
+func (self *key_data) IsDeleted() bool {
+	return self.delcount > self.addcount
+}
+
+func (self *key_data) Delete() {
+	self.delcount = self.addcount + 1
+	self.pass = ""
+	self.fields = nil
+	self.history = nil
+	self.touch()
+}
+
+func (self *key_data) Merge(other Key) {
+	okey := other.keyData()
+
+	if self.delcount < okey.delcount {
+		self.delcount = okey.delcount
+	}
+	history := make([]password_version, 0, len(self.history)+len(okey.history)+1)
+	history = append(history, self.history...)
+	history = append(history, okey.history...)
+	// the losing password is kept in the history, unless it is already known
+	lost := okey.pass
+	if self.addcount < okey.addcount {
+		lost = self.pass
+		self.pass = okey.pass
+		self.addcount = okey.addcount
+	}
+	known := false
+	for _, version := range history {
+		known = known || version.pass == lost
+	}
+	if !known {
+		history = append(history, password_version{lost, time.Now().UTC().Truncate(time.Second)})
+	}
+	self.setHistory(history)
+	if self.modified.Before(okey.modified) {
+		self.fields = okey.Fields()
+		self.modified = okey.modified
+	}
+	if self.created.IsZero() || (!okey.created.IsZero() && okey.created.Before(self.created)) {
+		self.created = okey.created
+	}
+	if self.IsDeleted() {
+		self.pass = ""
+		self.fields = nil
+		self.history = nil
+	}
+}
+
+func (self *key_data) Conflicts(other Key) bool {
+	okey := other.keyData()
+
+	if self.IsDeleted() || okey.IsDeleted() || self.pass == okey.pass {
+		return false
+	}
+	if self.addcount == okey.addcount {
+		return true
+	}
+
+	newer, older := self, okey
+	if newer.addcount < older.addcount {
+		newer, older = older, newer
+	}
+	if len(newer.history) == 0 || newer.addcount-older.addcount > int64(len(newer.history)) {
+		// not enough history to tell: the counters decide
+		return false
+	}
+	for _, version := range newer.history {
+		if version.pass == older.pass {
+			return false
+		}
+	}
+	return true
+}
+
+// The resolved key supersedes both versions, and keeps the discarded
+// password in its history
+func (self *key_data) Resolve(other Key, useOther bool) {
+	pass := self.pass
+	if useOther {
+		pass = other.keyData().pass
+	}
+	self.Merge(other)
+	if self.pass == pass {
+		self.addcount = self.addcount + 1
+		self.touch()
+	} else {
+		self.SetPassword(pass)
+	}
+}
+
+func (self *key_data) SetPassword(pass string) {
+	old := self.pass
+	self.pass = pass
+	if !self.IsDeleted() {
+		self.setHistory(append([]password_version{{old, time.Now().UTC().Truncate(time.Second)}}, self.history...))
+	}
+	self.addcount = self.addcount + 1
+	self.touch()
+}
+
+// Set the history: most recent first, without duplicates nor the
+// current password, and bounded to history_size versions
+func (self *key_data) setHistory(versions []password_version) {
+	sort.Stable(by_date(versions))
+	history := make([]password_version, 0, history_size)
+	known := map[string]bool{self.pass: true, "": true}
+	for _, version := range versions {
+		if !known[version.pass] && len(history) < history_size {
+			history = append(history, version)
+			known[version.pass] = true
+		}
+	}
+	self.history = history
+}
+
+type by_date []password_version
+
+func (self by_date) Len() int           { return len(self) }
+func (self by_date) Less(i, j int) bool { return self[i].date.After(self[j].date) }
+func (self by_date) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+func (self *key_data) History() (result []time.Time) {
+	result = make([]time.Time, len(self.history))
+	for i, version := range self.history {
+		result[i] = version.date
+	}
+	return
+}
+
+func (self *key_data) Rollback(n int) (err error) {
+	if self.IsDeleted() {
+		return errors.Newf("Unknown key: %s", self.name)
+	}
+	if n < 1 || n > len(self.history) {
+		return errors.Newf("No such previous password for %s: %d", self.name, n)
+	}
+	pass := self.history[n-1].pass
+	self.SetPassword(pass)
+	return
+}
+
+func (self *key_data) touch() {
+	self.modified = time.Now().UTC().Truncate(time.Second)
+	if self.created.IsZero() {
+		self.created = self.modified
+	}
+}
+
+func (self *key_data) Field(name string) string {
+	return self.fields[name]
+}
+
+// Return a copy of all the metadata fields
+func (self *key_data) Fields() (result map[string]string) {
+	result = make(map[string]string, len(self.fields))
+	for name, value := range self.fields {
+		result[name] = value
+	}
+	return
+}
+
+var field_name = regexp.MustCompile("^[A-Za-z0-9_.-]+$")
+
+// Set a metadata field; an empty value removes the field
+func (self *key_data) SetField(name string, value string) (err error) {
+	if self.IsDeleted() {
+		return errors.Newf("Unknown key: %s", self.name)
+	}
+	if !field_name.MatchString(name) {
+		return errors.Newf("Invalid field name: '%s'", name)
+	}
+	switch name {
+	case "password", "created", "modified":
+		return errors.Newf("Reserved field name: '%s'", name)
+	}
+	if value == "" {
+		delete(self.fields, name)
+	} else {
+		if self.fields == nil {
+			self.fields = make(map[string]string)
+		}
+		self.fields[name] = value
+	}
+	self.touch()
+	return
+}
+
+func (self *key_data) Created() time.Time {
+	return self.created
+}
+
+func (self *key_data) Modified() time.Time {
+	return self.modified
+}
+
+// The metadata are stored after the key record, as lines starting
+// with a tab:
+//
+//	\tcreated:<unix time>
+//	\tmodified:<unix time>
+//	\tfield:<name>:<quoted value>
+//	\thistory:<unix time>:<quoted password>
+func (self *key_data) encodedMeta() string {
+	lines := make([]string, 0, len(self.fields)+2)
+	if !self.created.IsZero() {
+		lines = append(lines, fmt.Sprintf("\tcreated:%d\n", self.created.Unix()))
+	}
+	if !self.modified.IsZero() {
+		lines = append(lines, fmt.Sprintf("\tmodified:%d\n", self.modified.Unix()))
+	}
+	names := make([]string, 0, len(self.fields))
+	for name := range self.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("\tfield:%s:%s\n", name, strconv.Quote(self.fields[name])))
+	}
+	for _, version := range self.history {
+		lines = append(lines, fmt.Sprintf("\thistory:%d:%s\n", version.date.Unix(), strconv.Quote(version.pass)))
+	}
+	return strings.Join(lines, "")
+}
+
+// Decode a metadata line (without its leading tab)
+func (self *key_data) decodeMeta(line string) (err error) {
+	parts := strings.SplitN(line, ":", 3)
+	switch {
+	case len(parts) == 2 && (parts[0] == "created" || parts[0] == "modified"):
+		var t int64
+		t, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return errors.Decorated(err)
+		}
+		if parts[0] == "created" {
+			self.created = time.Unix(t, 0).UTC()
+		} else {
+			self.modified = time.Unix(t, 0).UTC()
+		}
+	case len(parts) == 3 && parts[0] == "field":
+		var value string
+		value, err = strconv.Unquote(parts[2])
+		if err != nil {
+			return errors.Decorated(err)
+		}
+		if self.fields == nil {
+			self.fields = make(map[string]string)
+		}
+		self.fields[parts[1]] = value
+	case len(parts) == 3 && parts[0] == "history":
+		var t int64
+		t, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return errors.Decorated(err)
+		}
+		var pass string
+		pass, err = strconv.Unquote(parts[2])
+		if err != nil {
+			return errors.Decorated(err)
+		}
+		self.history = append(self.history, password_version{pass, time.Unix(t, 0).UTC()})
+	default:
+		return errors.Newf("Invalid metadata for key %s", self.name)
+	}
+	return
+}
+
+// A vault records format
+type record_format struct {
+	// sends each problem found on the channel, then io.EOF
+	decode func(*vault, io.ReadCloser, chan error)
+	newkey func(string, string) Key
+}
+
+// The records format used by new vaults
+const default_records = "scrypt"
+
+var record_formats = map[string]record_format{
+	"bf":     {bf_decode, bf_newkey},
+	"scrypt": {scrypt_decode, scrypt_newkey},
+}
+
+func recordFormat(name string) (result record_format, err error) {
+	result, ok := record_formats[name]
+	if !ok {
+		err = errors.Newf("Unknown vault records format: %s", name)
+	}
+	return
+}
+
+// A problem found while decoding a vault record. The record itself is
+// not shown: it holds a password.
+type record_error struct {
+	line    int
+	message string
+}
+
+func (self record_error) Error() string {
+	return fmt.Sprintf("line %d: %s", self.line, self.message)
+}
+
+func recordError(line int, format string, args ...interface{}) error {
+	return record_error{line, fmt.Sprintf(format, args...)}
+}
+
+// The message of an error, without its stack trace
+func errorMessage(err error) string {
+	if e, ok := err.(errors.StackError); ok {
+		err = e.Nested
+	}
+	return err.Error()
+}
+
+// Check a decoded key before adding it to the vault data
+func checkRecord(data map[string]Key, line int, k Key) error {
+	kd := k.keyData()
+	if _, ok := data[kd.name]; ok {
+		return recordError(line, "duplicate key %s", kd.name)
+	}
+	if kd.delcount > kd.addcount+1 {
+		return recordError(line, "impossible counters for key %s: added %d times, deleted %d times", kd.name, kd.addcount, kd.delcount)
+	}
+	return nil
 }
 
 func decode_group(dec *regexp.Regexp, data string, name string, match []int) (result string) {
@@ -0,0 +1,143 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+// Legacy vaults written by "openssl <cipher> -a -pass ..." (read only)
+
+import (
+	"gate/core/errors"
+)
+
+import (
+	"bytes"
+	"code.google.com/p/go.crypto/blowfish"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/sha256"
+	"hash"
+	"unicode/utf8"
+)
+
+var openssl_magic = []byte("Salted__")
+
+const openssl_salt_size = 8
+
+type openssl_cipher struct {
+	name      string
+	newBlock  func(key []byte) (cipher.Block, error)
+	keySize   int
+	blockSize int
+}
+
+var _ Cipher = &openssl_cipher{}
+
+func newBlowfish(key []byte) (cipher.Block, error) {
+	return blowfish.NewCipher(key)
+}
+
+func newOpensslCipher(name string) (result Cipher, err error) {
+	switch name {
+	case "bf", "bf-cbc":
+		result = &openssl_cipher{name, newBlowfish, 16, blowfish.BlockSize}
+	case "aes-128-cbc":
+		result = &openssl_cipher{name, aes.NewCipher, 16, aes.BlockSize}
+	case "aes-192-cbc":
+		result = &openssl_cipher{name, aes.NewCipher, 24, aes.BlockSize}
+	case "aes-256-cbc":
+		result = &openssl_cipher{name, aes.NewCipher, 32, aes.BlockSize}
+	default:
+		err = errors.Newf("Unknown cipher: %s", name)
+	}
+	return
+}
+
+func (self *openssl_cipher) Name() string {
+	return self.name
+}
+
+func (self *openssl_cipher) Parameters() map[string]string {
+	return nil
+}
+
+// openssl's EVP_BytesToKey with a single iteration
+func bytesToKey(digest func() hash.Hash, master, salt []byte, keySize, ivSize int) (key, iv []byte) {
+	var (
+		data []byte
+		last []byte
+	)
+	for len(data) < keySize+ivSize {
+		h := digest()
+		h.Write(last)
+		h.Write(master)
+		h.Write(salt)
+		last = h.Sum(nil)
+		data = append(data, last...)
+	}
+	return data[:keySize], data[keySize : keySize+ivSize]
+}
+
+func (self *openssl_cipher) decrypt(digest func() hash.Hash, salt, data []byte, master string) (result []byte, ok bool) {
+	key, iv := bytesToKey(digest, []byte(master), salt, self.keySize, self.blockSize)
+	block, err := self.newBlock(key)
+	if err != nil {
+		return
+	}
+
+	result = make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(result, data)
+
+	// PKCS#5 padding
+	pad := int(result[len(result)-1])
+	if pad == 0 || pad > self.blockSize {
+		return nil, false
+	}
+	for _, b := range result[len(result)-pad:] {
+		if int(b) != pad {
+			return nil, false
+		}
+	}
+	result = result[:len(result)-pad]
+
+	// the vault is made of text lines
+	ok = utf8.Valid(result)
+	return
+}
+
+func (self *openssl_cipher) Decrypt(data []byte, master string) (result []byte, err error) {
+	if !bytes.HasPrefix(data, openssl_magic) || len(data) < len(openssl_magic)+openssl_salt_size {
+		return nil, errors.New("Invalid legacy vault: missing salt")
+	}
+	salt := data[len(openssl_magic) : len(openssl_magic)+openssl_salt_size]
+	data = data[len(openssl_magic)+openssl_salt_size:]
+	if len(data) == 0 || len(data)%self.blockSize != 0 {
+		return nil, errors.New("Invalid legacy vault: truncated data")
+	}
+
+	// openssl used md5 to derive keys up to 1.0.x, and sha256 since 1.1.0
+	for _, digest := range []func() hash.Hash{md5.New, sha256.New} {
+		var ok bool
+		result, ok = self.decrypt(digest, salt, data, master)
+		if ok {
+			return
+		}
+	}
+	return nil, errors.New("Could not decrypt vault: bad master or corrupted vault")
+}
+
+func (self *openssl_cipher) Encrypt(data []byte, master string) (result []byte, err error) {
+	return nil, errors.Newf("Legacy cipher %s is read only", self.name)
+}
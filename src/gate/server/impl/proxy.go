@@ -23,18 +23,23 @@ import (
 	"gate/server/channel"
 )
 
+import (
+	"time"
+)
+
 type proxy struct {
 	channel channel.ChannelClient
 }
 
 var _ server.Server = &proxy{}
 
-type ProxyStartFunc func() error
-
 // Return a new proxy to the Gate server identified by the host name and port.
-func Proxy(config core.Config, startFunc ProxyStartFunc) (result server.Server, err error) {
+func Proxy(config core.Config, startFunc server.ProxyStartFunc) (result server.Server, err error) {
 	p := &proxy{}
-	p.channel = channel.HttpChannelClient(config, startFunc, p)
+	p.channel, err = channel.NewChannelClient(config, startFunc, p)
+	if err != nil {
+		return
+	}
 	err = p.channel.Connect()
 	if err == nil {
 		result = p
@@ -62,6 +67,10 @@ func (self *proxy) Merge(args server.MergeArgs, reply *bool) error {
 	return self.channel.Merge(args, reply)
 }
 
+func (self *proxy) Diff(args server.MergeArgs, reply *server.MergeDiff) error {
+	return self.channel.Diff(args, reply)
+}
+
 func (self *proxy) Save(force bool, reply *bool) error {
 	return self.channel.Save(force, reply)
 }
@@ -80,6 +89,10 @@ func (self *proxy) Stop(status int, reply *bool) (err error) {
 	return
 }
 
+func (self *proxy) Lock(reason string, reply *bool) error {
+	return self.channel.Lock(reason, reply)
+}
+
 func (self *proxy) Ping(info string, reply *string) error {
 	return self.channel.Ping(info, reply)
 }
@@ -87,3 +100,47 @@ func (self *proxy) Ping(info string, reply *string) error {
 func (self *proxy) SetMaster(master string, reply *bool) error {
 	return self.channel.SetMaster(master, reply)
 }
+
+func (self *proxy) Info(args server.InfoArgs, reply *server.KeyInfo) error {
+	return self.channel.Info(args, reply)
+}
+
+func (self *proxy) SetField(args server.SetFieldArgs, reply *bool) error {
+	return self.channel.SetField(args, reply)
+}
+
+func (self *proxy) History(key string, reply *[]time.Time) error {
+	return self.channel.History(key, reply)
+}
+
+func (self *proxy) Rollback(args server.RollbackArgs, reply *string) error {
+	return self.channel.Rollback(args, reply)
+}
+
+func (self *proxy) Conflicts(filter string, reply *[]server.Conflict) error {
+	return self.channel.Conflicts(filter, reply)
+}
+
+func (self *proxy) Resolve(args server.ResolveArgs, reply *bool) error {
+	return self.channel.Resolve(args, reply)
+}
+
+func (self *proxy) Backups(filter string, reply *[]server.Backup) error {
+	return self.channel.Backups(filter, reply)
+}
+
+func (self *proxy) RestoreBackup(name string, reply *bool) error {
+	return self.channel.RestoreBackup(name, reply)
+}
+
+func (self *proxy) Verify(args server.VerifyArgs, reply *server.VerifyReport) error {
+	return self.channel.Verify(args, reply)
+}
+
+func (self *proxy) Load(args server.MergeArgs, reply *bool) error {
+	return self.channel.Load(args, reply)
+}
+
+func (self *proxy) Changes(vault string, reply *server.MergeDiff) error {
+	return self.channel.Changes(vault, reply)
+}
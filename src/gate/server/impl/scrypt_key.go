@@ -17,12 +17,16 @@ package impl
 
 // scrypt vault keys (strong keys)
 
+import (
+	"gate/core/errors"
+)
+
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"io"
-	"os"
 	"regexp"
 	"strings"
 )
@@ -30,92 +34,78 @@ import (
 var _ Key = &scrypt_key{}
 
 type scrypt_key struct {
-	name	 string
-	salt	 string
-	pass	 string
-	delcount int64
-	addcount int64
-}
-
-func (self *scrypt_key) Name() string {
-	return self.name
-}
-
-func (self *scrypt_key) Password() string {
-	if self.IsDeleted() {
-		return ""
-	}
-	return self.pass
-}
-
-func (self *scrypt_key) IsDeleted() bool {
-	return self.delcount > self.addcount
-}
-
-func (self *scrypt_key) Delete() {
-	self.delcount = self.addcount + 1
+	key_data
+	salt string
 }
 
 func (self *scrypt_key) Encoded() string {
-	return fmt.Sprintf("%s:%s:%d:%d:%s\n", self.name, self.salt, self.addcount, self.delcount, self.pass)
-}
-
-func (self *scrypt_key) Merge(other Key) {
-	okey := other.(*scrypt_key)
-
-	if self.delcount < okey.delcount {
-		self.delcount = okey.delcount
-	}
-	if self.addcount < okey.addcount {
-		self.pass = okey.pass
-		self.addcount = okey.addcount
-	}
+	return fmt.Sprintf("%s:%s:%d:%d:%s\n%s", self.name, self.salt, self.addcount, self.delcount, self.pass, self.encodedMeta())
 }
 
-func (self *scrypt_key) SetPassword(pass string) {
-	self.pass = pass
-	self.addcount = self.addcount + 1
-}
-
-var scrypt_decoder = regexp.MustCompile("(?P<name>[^:]+):(?P<salt>[^:]+):(?P<add>[0-9]+):(?P<del>[0-9]+):(?P<pass>.*)")
+var scrypt_decoder = regexp.MustCompile("^(?P<name>[^:]+):(?P<salt>[^:]+):(?P<add>[0-9]+):(?P<del>[0-9]+):(?P<pass>.*)")
 
 func scrypt_decode(v *vault, out io.ReadCloser, barrier chan error) {
 	buffer := bytes.NewBuffer(make([]byte, 0, 4096))
 	_, err := buffer.ReadFrom(out)
 	if err != nil {
-		barrier <- err
+		barrier <- errors.Decorated(err)
+		barrier <- io.EOF
 		return
 	}
 	data := string(buffer.Bytes())
 
-	for _, line := range strings.Split(data, "\n") {
-		if line != "" {
-			linematch := scrypt_decoder.FindSubmatchIndex([]byte(line))
+	var last Key
+	for i, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			if last == nil {
+				barrier <- recordError(i+1, "metadata without key")
+				continue
+			}
+			err = last.keyData().decodeMeta(line[1:])
+			if err != nil {
+				barrier <- recordError(i+1, "invalid metadata for key %s", last.Name())
+			}
+		} else if line != "" {
+			last = nil
+			linematch := scrypt_decoder.FindStringSubmatchIndex(line)
+			if linematch == nil {
+				barrier <- recordError(i+1, "malformed record")
+				continue
+			}
 			name := decode_group(scrypt_decoder, line, "name", linematch)
-			salt64 := decode_group(scrypt_decoder, line, "salt", linematch)
-			salt, err := base64.StdEncoding.DecodeString(salt64)
+			salt := decode_group(scrypt_decoder, line, "salt", linematch)
+			_, err := base64.StdEncoding.DecodeString(salt)
 			if err != nil {
-				barrier <- err
+				barrier <- recordError(i+1, "invalid salt for key %s", name)
 				continue
 			}
 			pass := decode_group(scrypt_decoder, line, "pass", linematch)
 			delcount, err := decode_group_int(scrypt_decoder, line, "del", linematch)
 			if err != nil {
-				barrier <- err
+				barrier <- recordError(i+1, "invalid counter for key %s", name)
 				continue
 			}
 			addcount, err := decode_group_int(scrypt_decoder, line, "add", linematch)
 			if err != nil {
-				barrier <- err
+				barrier <- recordError(i+1, "invalid counter for key %s", name)
 				continue
 			}
 
 			k := &scrypt_key{
-				name:	  name,
-				salt:	  string(salt),
-				pass:	  pass,
-				delcount: delcount,
-				addcount: addcount,
+				key_data{
+					name:     name,
+					pass:     pass,
+					delcount: delcount,
+					addcount: addcount,
+				},
+				salt,
+			}
+			// a rejected key still gets its metadata, but is not kept
+			last = k
+			err = checkRecord(v.data, i+1, k)
+			if err != nil {
+				barrier <- err
+				continue
 			}
 			v.data[name] = k
 		}
@@ -126,37 +116,25 @@ func scrypt_decode(v *vault, out io.ReadCloser, barrier chan error) {
 
 func scrypt_newkey(name string, pass string) Key {
 	k := &scrypt_key{
-		name:	  name,
-		pass:	  pass,
-		delcount: 0,
-		addcount: 1,
+		key_data: key_data{
+			name:     name,
+			pass:     pass,
+			delcount: 0,
+			addcount: 1,
+		},
 	}
 	k.set_salt()
+	k.touch()
 	return k
 }
 
-func (self *scrypt_key) set_salt() {
-	in, err := os.Open("/dev/random")
-	if err != nil {
-		return
-	}
-	defer in.Close()
+const scrypt_salt_size = 16
 
-	data := make([]byte, 0, 2)
-	length := 0
-	for length == 0 {
-		n, err := in.Read(data)
-		if err != nil {
-			return
-		}
-		if n == 2 {
-			length = (int(data[0])&0xff)<<8 | int(data[1])
-		}
-	}
-	salt := make([]byte, 0, length)
-	n, err := in.Read(data)
+func (self *scrypt_key) set_salt() {
+	salt := make([]byte, scrypt_salt_size)
+	_, err := io.ReadFull(rand.Reader, salt)
 	if err != nil {
 		return
 	}
-	self.salt = base64.StdEncoding.EncodeToString(salt[0:n])
+	self.salt = base64.StdEncoding.EncodeToString(salt)
 }
@@ -28,6 +28,9 @@ import (
 	"io"
 	"log"
 	"os"
+	"regexp"
+	"sync"
+	"time"
 )
 
 // A server-side server and extra (non-exported) methods
@@ -36,12 +39,27 @@ type ServerLocal interface {
 	Wait() (int, error)
 }
 
+// The RPC operations are served concurrently. The mutex guards the
+// vault and the server state: shared by the read-only operations,
+// exclusive for the others. The useMutex guards lastUse, updated by
+// all the operations.
 type serverImpl struct {
-	vault	Vault
-	config	core.Config
-	channel channel.ChannelServer
-	running bool
-	status	chan int
+	vault     Vault
+	vaultPath string
+	backups   *backups
+	config    core.Config
+	channel   channel.ChannelServer
+	running   bool
+	status    chan int
+	mutex     sync.RWMutex
+	useMutex  sync.Mutex
+	locked    bool
+	lockAfter time.Duration
+	maxUnlock time.Duration
+	idleTimer *time.Timer
+	maxTimer  *time.Timer
+	lastUse   time.Time
+	openedAt  time.Time
 }
 
 type serverLocal struct {
@@ -51,12 +69,20 @@ type serverLocal struct {
 var _ server.Server = &serverImpl{}
 var _ ServerLocal = &serverLocal{}
 
-func newVault(file string) Vault {
+// The vault file is replaced atomically when saved; the previous
+// one is backed up first (unless backups is nil)
+func newVault(file string, backups *backups) Vault {
 	in := func() (result io.ReadCloser, err error) {
 		return os.Open(file)
 	}
 	out := func() (result io.WriteCloser, err error) {
-		return os.Create(file)
+		if backups != nil {
+			err = backups.backup(file)
+			if err != nil {
+				return
+			}
+		}
+		return createAtomic(file)
 	}
 	return NewVault(in, out)
 }
@@ -70,13 +96,33 @@ func Start(config core.Config) (result ServerLocal, err error) {
 		return
 	}
 
+	lockAfter, err := durationConfig(config, "lock_after")
+	if err != nil {
+		return
+	}
+	maxUnlock, err := durationConfig(config, "max_unlock")
+	if err != nil {
+		return
+	}
+	backups, err := newBackups(config)
+	if err != nil {
+		return
+	}
+
 	srv := &serverImpl{
-		vault:	 newVault(vault_path),
-		config:	 config,
-		status:	 make(chan int),
-		running: true,
+		vault:     newVault(vault_path, backups),
+		vaultPath: vault_path,
+		backups:   backups,
+		config:    config,
+		status:    make(chan int, 1),
+		running:   true,
+		lockAfter: lockAfter,
+		maxUnlock: maxUnlock,
+	}
+	srv.channel, err = channel.NewChannelServer(config, srv)
+	if err != nil {
+		return
 	}
-	srv.channel = channel.HttpChannelServer(config, srv)
 
 	err = srv.channel.Bind()
 	if err != nil {
@@ -91,11 +137,123 @@ func Start(config core.Config) (result ServerLocal, err error) {
 	return
 }
 
+// Read a duration from the [server] section; zero if not set
+func durationConfig(config core.Config, key string) (result time.Duration, err error) {
+	value, e := config.Eval("", "server", key, os.Getenv)
+	if e != nil || value == "" {
+		return
+	}
+	result, err = time.ParseDuration(value)
+	if err != nil {
+		err = errors.Newf("Invalid duration for [server] %s: '%s'", key, value)
+	}
+	return
+}
+
+// The error returned when the vault is not open; it tells if the
+// vault was locked, so that the client can ask the master again
+func (self *serverImpl) closedError(format string, args ...interface{}) error {
+	if self.locked {
+		return errors.New(server.LockedMessage)
+	}
+	return errors.Newf(format, args...)
+}
+
+// Start the lock timers (if configured) when the vault is opened
+func (self *serverImpl) startTimers() {
+	self.stopTimers()
+	self.openedAt = time.Now()
+	self.lastUse = self.openedAt
+	if self.lockAfter > 0 {
+		self.idleTimer = time.AfterFunc(self.lockAfter, func() {
+			self.lock("idle", func() bool {
+				return self.idleTime() >= self.lockAfter
+			})
+		})
+	}
+	if self.maxUnlock > 0 {
+		self.maxTimer = time.AfterFunc(self.maxUnlock, func() {
+			self.lock("maximum unlock duration", func() bool {
+				return time.Since(self.openedAt) >= self.maxUnlock
+			})
+		})
+	}
+}
+
+func (self *serverImpl) stopTimers() {
+	if self.idleTimer != nil {
+		self.idleTimer.Stop()
+		self.idleTimer = nil
+	}
+	if self.maxTimer != nil {
+		self.maxTimer.Stop()
+		self.maxTimer = nil
+	}
+}
+
+// Reset the idle timer. Called by the read operations: the mutex may
+// be shared, hence the separate lock.
+func (self *serverImpl) touch() {
+	self.useMutex.Lock()
+	defer self.useMutex.Unlock()
+	self.lastUse = time.Now()
+	if self.idleTimer != nil {
+		self.idleTimer.Reset(self.lockAfter)
+	}
+}
+
+func (self *serverImpl) idleTime() time.Duration {
+	self.useMutex.Lock()
+	defer self.useMutex.Unlock()
+	return time.Since(self.lastUse)
+}
+
+// Called by the lock timers. The timer may have fired while the vault
+// was in use: expired tells if the vault must still be locked.
+func (self *serverImpl) lock(reason string, expired func() bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.vault.IsOpen() || !expired() {
+		return
+	}
+	err := self.lockVault(reason)
+	if err != nil {
+		log.Printf("Could not lock vault: %s", err)
+	}
+}
+
+// Save and close the vault; the master is needed to open it again.
+func (self *serverImpl) lockVault(reason string) (err error) {
+	log.Printf("Locking vault (%s)", reason)
+	err = self.vault.Close(self.config)
+	if err != nil {
+		return
+	}
+	self.stopTimers()
+	self.locked = true
+	return
+}
+
+// Save the vault if it is still open (and dirty) when the server stops
+func (self *serverImpl) flush() (err error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.stopTimers()
+	if self.vault.IsOpen() {
+		log.Printf("Saving vault before exit")
+		err = self.vault.Close(self.config)
+	}
+	return
+}
+
 func (self *serverImpl) IsOpen(thenClose bool, reply *bool) (err error) {
 	log.Printf("IsOpen(thenClose=%t)", thenClose)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 	if self.vault.IsOpen() {
 		*reply = true
 		if thenClose {
+			self.stopTimers()
 			err = self.vault.Close(self.config)
 		}
 	} else {
@@ -106,8 +264,14 @@ func (self *serverImpl) IsOpen(thenClose bool, reply *bool) (err error) {
 
 func (self *serverImpl) Get(name string, reply *string) (err error) {
 	log.Printf("Get(name='%s')", name)
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.get(name, reply)
+}
+
+func (self *serverImpl) get(name string, reply *string) (err error) {
 	if !self.vault.IsOpen() {
-		return errors.Newf("Vault is not open: cannot get %s", name)
+		return self.closedError("Vault is not open: cannot get %s", name)
 	}
 	key, err := self.vault.Item(name)
 	if err != nil {
@@ -117,41 +281,49 @@ func (self *serverImpl) Get(name string, reply *string) (err error) {
 		return errors.Newf("Unknown key %s", name)
 	}
 	*reply = key.Password()
+	self.touch()
 	return
 }
 
 func (self *serverImpl) List(filter string, reply *[]string) (err error) {
 	log.Printf("List(filter='%s')", filter)
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
 	if !self.vault.IsOpen() {
-		return errors.Newf("Vault is not open: cannot list")
+		return self.closedError("Vault is not open: cannot list")
 	}
 	*reply, err = self.vault.List(filter)
+	self.touch()
 	return
 }
 
 func (self *serverImpl) Open(master string, reply *bool) (err error) {
 	log.Printf("Open(master='***')")
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 	if self.vault.IsOpen() {
 		return errors.Newf("Vault is already open: cannot open")
 	}
 	err = self.vault.Open(master, self.config)
 	*reply = err == nil
+	if err == nil {
+		self.locked = false
+		self.startTimers()
+	}
 	return
 }
 
 func (self *serverImpl) Merge(args server.MergeArgs, reply *bool) (err error) {
 	log.Printf("Merge(vault='%s', master='***')", args.Vault)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 	if !self.vault.IsOpen() {
-		return errors.Newf("Vault is not open: cannot merge")
+		return self.closedError("Vault is not open: cannot merge")
 	}
-	vault := newVault(args.Vault)
-	err = vault.Open(args.Master, self.config)
+	vault, err := self.openMergeVault(args)
 	if err != nil {
 		return
 	}
-	if !vault.IsOpen() {
-		return errors.Newf("Merge vault is not open: cannot merge")
-	}
 	err = self.vault.Merge(vault)
 	if err != nil {
 		vault.Close(self.config)
@@ -165,10 +337,64 @@ func (self *serverImpl) Merge(args server.MergeArgs, reply *bool) (err error) {
 	return
 }
 
+func (self *serverImpl) openMergeVault(args server.MergeArgs) (result Vault, err error) {
+	_, err = os.Stat(args.Vault)
+	if err != nil {
+		return nil, errors.Decorated(err)
+	}
+	result = newVault(args.Vault, nil)
+	err = result.Open(args.Master, self.config)
+	if err != nil {
+		return
+	}
+	if !result.IsOpen() {
+		return nil, errors.Newf("Merge vault is not open: cannot merge")
+	}
+	return
+}
+
+func (self *serverImpl) Diff(args server.MergeArgs, reply *server.MergeDiff) (err error) {
+	log.Printf("Diff(vault='%s', master='***')", args.Vault)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.vault.IsOpen() {
+		return self.closedError("Vault is not open: cannot diff")
+	}
+	vault, err := self.openMergeVault(args)
+	if err != nil {
+		return
+	}
+	// closed without saving: nothing must change
+	defer vault.Close(nil)
+	*reply, err = self.vault.Diff(vault)
+	return
+}
+
+// The previous version of the vault must have the same master
+func (self *serverImpl) Changes(vault string, reply *server.MergeDiff) (err error) {
+	log.Printf("Changes(vault='%s')", vault)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.vault.IsOpen() {
+		return self.closedError("Vault is not open: cannot compare")
+	}
+	_, err = os.Stat(vault)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	in := func() (result io.ReadCloser, err error) {
+		return os.Open(vault)
+	}
+	*reply, err = self.vault.Changes(in, self.config)
+	return
+}
+
 func (self *serverImpl) Save(force bool, reply *bool) (err error) {
 	log.Printf("Save(force=%t)", force)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 	if !self.vault.IsOpen() {
-		return errors.Newf("Vault is not open: cannot save")
+		return self.closedError("Vault is not open: cannot save")
 	}
 	err = self.vault.Save(force, self.config)
 	if err != nil {
@@ -180,8 +406,10 @@ func (self *serverImpl) Save(force bool, reply *bool) (err error) {
 
 func (self *serverImpl) Set(args server.SetArgs, reply *string) (err error) {
 	log.Printf("Set(key='%s', ...)", args.Key)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 	if !self.vault.IsOpen() {
-		return errors.Newf("Vault is not open: cannot set")
+		return self.closedError("Vault is not open: cannot set")
 	}
 	if args.Recipe != "" {
 		err = self.vault.SetRandom(args.Key, args.Recipe)
@@ -191,14 +419,16 @@ func (self *serverImpl) Set(args server.SetArgs, reply *string) (err error) {
 	if err != nil {
 		return
 	}
-	err = self.Get(args.Key, reply)
+	err = self.get(args.Key, reply)
 	return
 }
 
 func (self *serverImpl) Unset(key string, reply *bool) (err error) {
 	log.Printf("Unset(key='%s')", key)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 	if !self.vault.IsOpen() {
-		return errors.Newf("Vault is not open: cannot unset")
+		return self.closedError("Vault is not open: cannot unset")
 	}
 	err = self.vault.Unset(key)
 	*reply = err == nil
@@ -207,6 +437,14 @@ func (self *serverImpl) Unset(key string, reply *bool) (err error) {
 
 func (self *serverImpl) Stop(status int, reply *bool) (err error) {
 	log.Printf("Stop(status=%d)", status)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.running {
+		// already stopping
+		*reply = true
+		return
+	}
+	self.stopTimers()
 	if self.vault.IsOpen() {
 		err = self.vault.Close(self.config)
 		if err != nil {
@@ -214,21 +452,42 @@ func (self *serverImpl) Stop(status int, reply *bool) (err error) {
 		}
 	}
 	self.running = false
-	self.status <- status
+	self.status <- status // buffered: Wait may not be waiting yet
+	close(self.status)
+	*reply = true
+	return
+}
+
+func (self *serverImpl) Lock(reason string, reply *bool) (err error) {
+	log.Printf("Lock(reason='%s')", reason)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.vault.IsOpen() {
+		err = self.lockVault(reason)
+		if err != nil {
+			return
+		}
+	}
 	*reply = true
 	return
 }
 
 func (self *serverImpl) Ping(info string, reply *string) (err error) {
 	log.Printf("Ping(info='%s')", info)
-	*reply = info
+	if info == server.ProtocolQuery {
+		*reply = server.ProtocolVersion
+	} else {
+		*reply = info
+	}
 	return
 }
 
 func (self *serverImpl) SetMaster(master string, reply *bool) (err error) {
 	log.Printf("SetMaster(master='***')")
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
 	if !self.vault.IsOpen() {
-		return errors.Newf("Vault is not open: cannot set master")
+		return self.closedError("Vault is not open: cannot set master")
 	}
 	err = self.vault.SetMaster(master)
 	if err == nil {
@@ -237,13 +496,247 @@ func (self *serverImpl) SetMaster(master string, reply *bool) (err error) {
 	return
 }
 
+func (self *serverImpl) Info(args server.InfoArgs, reply *server.KeyInfo) (err error) {
+	log.Printf("Info(key='%s', password=%t)", args.Key, args.WithPassword)
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	if !self.vault.IsOpen() {
+		return self.closedError("Vault is not open: cannot get info for %s", args.Key)
+	}
+	key, err := self.vault.Item(args.Key)
+	if err != nil {
+		return
+	}
+	if key == nil || key.IsDeleted() {
+		return errors.Newf("Unknown key %s", args.Key)
+	}
+	fields := key.Fields()
+	info := server.KeyInfo{
+		Name:     key.Name(),
+		Username: fields[Username],
+		Url:      fields[Url],
+		Notes:    fields[Notes],
+		Fields:   fields,
+		Created:  key.Created(),
+		Modified: key.Modified(),
+	}
+	delete(fields, Username)
+	delete(fields, Url)
+	delete(fields, Notes)
+	if args.WithPassword {
+		info.Password = key.Password()
+	}
+	*reply = info
+	return
+}
+
+func (self *serverImpl) SetField(args server.SetFieldArgs, reply *bool) (err error) {
+	log.Printf("SetField(key='%s', field='%s', ...)", args.Key, args.Field)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.vault.IsOpen() {
+		return self.closedError("Vault is not open: cannot set field")
+	}
+	err = self.vault.SetField(args.Key, args.Field, args.Value)
+	*reply = err == nil
+	return
+}
+
+func (self *serverImpl) History(name string, reply *[]time.Time) (err error) {
+	log.Printf("History(key='%s')", name)
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	if !self.vault.IsOpen() {
+		return self.closedError("Vault is not open: cannot get history of %s", name)
+	}
+	key, err := self.vault.Item(name)
+	if err != nil {
+		return
+	}
+	if key == nil || key.IsDeleted() {
+		return errors.Newf("Unknown key %s", name)
+	}
+	*reply = key.History()
+	return
+}
+
+func (self *serverImpl) Rollback(args server.RollbackArgs, reply *string) (err error) {
+	log.Printf("Rollback(key='%s', version=%d)", args.Key, args.Version)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.vault.IsOpen() {
+		return self.closedError("Vault is not open: cannot rollback")
+	}
+	err = self.vault.Rollback(args.Key, args.Version)
+	if err != nil {
+		return
+	}
+	err = self.get(args.Key, reply)
+	return
+}
+
+func (self *serverImpl) Conflicts(filter string, reply *[]server.Conflict) (err error) {
+	log.Printf("Conflicts(filter='%s')", filter)
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	if !self.vault.IsOpen() {
+		return self.closedError("Vault is not open: cannot list conflicts")
+	}
+	names, err := self.vault.Conflicts(filter)
+	if err != nil {
+		return
+	}
+	result := make([]server.Conflict, 0, len(names))
+	for _, name := range names {
+		var local, remote Key
+		local, err = self.vault.Item(name)
+		if err != nil {
+			return
+		}
+		remote, err = self.vault.Conflict(name)
+		if err != nil {
+			return
+		}
+		result = append(result, server.Conflict{
+			Key:            name,
+			LocalModified:  local.Modified(),
+			RemoteModified: remote.Modified(),
+		})
+	}
+	*reply = result
+	return
+}
+
+func (self *serverImpl) Resolve(args server.ResolveArgs, reply *bool) (err error) {
+	log.Printf("Resolve(key='%s', choice='%s')", args.Key, args.Choice)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.vault.IsOpen() {
+		return self.closedError("Vault is not open: cannot resolve")
+	}
+	err = self.vault.Resolve(args.Key, args.Choice)
+	*reply = err == nil
+	return
+}
+
+func (self *serverImpl) Backups(filter string, reply *[]server.Backup) (err error) {
+	log.Printf("Backups(filter='%s')", filter)
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	if !self.vault.IsOpen() {
+		return self.closedError("Vault is not open: cannot list backups")
+	}
+	re_filter, err := regexp.Compile(filter)
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	backups, err := self.backups.list()
+	if err != nil {
+		return
+	}
+	result := make([]server.Backup, 0, len(backups))
+	for _, backup := range backups {
+		if re_filter.MatchString(backup.Name) {
+			result = append(result, backup)
+		}
+	}
+	*reply = result
+	self.touch()
+	return
+}
+
+// Replace the server vault by the given vault file, after checking
+// it. The current vault is saved and backed up first; the new vault is
+// then opened with its own master.
+func (self *serverImpl) Load(args server.MergeArgs, reply *bool) (err error) {
+	log.Printf("Load(vault='%s', master='***')", args.Vault)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	in := func() (result io.ReadCloser, err error) {
+		return os.Open(args.Vault)
+	}
+	report, err := verifyVault(in, args.Master, self.config)
+	if err != nil {
+		return
+	}
+	if len(report.Problems) > 0 {
+		return errors.Newf("Invalid vault: %s (%d problem(s)): not loaded", report.Problems[0], len(report.Problems))
+	}
+
+	// saved (keeping both passwords of an interrupted merge), and
+	// locked until the loaded vault is open; the saved vault is backed
+	// up only once, below
+	if self.vault.IsOpen() {
+		self.backups.paused = true
+		err = self.lockVault("load")
+		self.backups.paused = false
+		if err != nil {
+			return
+		}
+	}
+	err = self.backups.backup(self.vaultPath)
+	if err != nil {
+		return
+	}
+	err = copyAtomic(args.Vault, self.vaultPath)
+	if err != nil {
+		return
+	}
+
+	// from now on, the previous vault is only in the backups
+	self.locked = true
+	err = self.vault.Open(args.Master, self.config)
+	if err != nil {
+		return
+	}
+	self.locked = false
+	self.startTimers()
+	*reply = true
+	return
+}
+
+// Does not need the server vault to be open: the vault file is read
+// again, with the given master.
+func (self *serverImpl) Verify(args server.VerifyArgs, reply *server.VerifyReport) (err error) {
+	log.Printf("Verify(vault='%s', master='***')", args.Vault)
+	file := args.Vault
+	if file == "" {
+		file = self.vaultPath
+	}
+	in := func() (result io.ReadCloser, err error) {
+		return os.Open(file)
+	}
+	*reply, err = verifyVault(in, args.Master, self.config)
+	return
+}
+
+// The vault is saved and closed before being replaced by the backup;
+// it is then locked: the backup may need another master.
+func (self *serverImpl) RestoreBackup(name string, reply *bool) (err error) {
+	log.Printf("RestoreBackup(name='%s')", name)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.vault.IsOpen() {
+		return self.closedError("Vault is not open: cannot restore backup")
+	}
+	err = self.lockVault("restore")
+	if err != nil {
+		return
+	}
+	err = self.backups.restore(name, self.vaultPath)
+	*reply = err == nil
+	return
+}
+
 func (self *serverLocal) Wait() (result int, err error) {
-	if self.server.running {
-		result = <-self.server.status
-		self.server.channel.Disconnect()
-	} else {
+	result, ok := <-self.server.status
+	if !ok {
 		err = errors.New("server not running")
+		return
 	}
+	self.server.channel.Disconnect()
+	err = self.server.flush()
 	return
 }
 
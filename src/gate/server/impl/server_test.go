@@ -0,0 +1,561 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+import (
+	"gate/core"
+	"gate/core/errors"
+	"gate/server"
+)
+
+import (
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, cfg core.Config, lockAfter, maxUnlock time.Duration) *serverImpl {
+	v := newTestVault(t, map[string]string{"foo": "bar"})
+	srv := &serverImpl{
+		vault:     v,
+		config:    cfg,
+		status:    make(chan int, 1),
+		running:   true,
+		lockAfter: lockAfter,
+		maxUnlock: maxUnlock,
+	}
+	srv.startTimers()
+	return srv
+}
+
+func TestIdleLock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	srv := newTestServer(t, cfg, 100*time.Millisecond, 0)
+
+	var pass string
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		err := srv.Get("foo", &pass)
+		if err != nil {
+			t.Fatalf("locked while in use: %s", err)
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	err := srv.Get("foo", &pass)
+	if !server.IsLocked(err) {
+		t.Fatalf("expected locked vault, got %v", err)
+	}
+	var isopen bool
+	err = srv.IsOpen(false, &isopen)
+	if err != nil || isopen {
+		t.Errorf("vault still open")
+	}
+}
+
+func TestMaxUnlock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	srv := newTestServer(t, cfg, time.Hour, 150*time.Millisecond)
+
+	var list []string
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		srv.List(".*", &list)
+	}
+	err := srv.List(".*", &list)
+	if !server.IsLocked(err) {
+		t.Fatalf("expected locked vault, got %v", err)
+	}
+}
+
+func TestNoLock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	srv := newTestServer(t, cfg, 0, 0)
+
+	time.Sleep(50 * time.Millisecond)
+	var pass string
+	err := srv.Get("foo", &pass)
+	if err != nil {
+		t.Error(err)
+	}
+	if pass != "bar" {
+		t.Errorf("bad password: '%s'", pass)
+	}
+}
+
+func TestLock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	srv := newTestServer(t, cfg, 0, 0)
+
+	var locked bool
+	err := srv.Lock("test", &locked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locked {
+		t.Errorf("vault not locked")
+	}
+	var pass string
+	err = srv.Get("foo", &pass)
+	if !server.IsLocked(err) {
+		t.Fatalf("expected locked vault, got %v", err)
+	}
+	if !srv.running {
+		t.Errorf("server stopped")
+	}
+}
+
+func TestPingProtocol(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	srv := newTestServer(t, cfg, 0, 0)
+
+	var reply string
+	err := srv.Ping("hello", &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "hello" {
+		t.Errorf("bad reply: '%s'", reply)
+	}
+	err = srv.Ping(server.ProtocolQuery, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != server.ProtocolVersion {
+		t.Errorf("bad protocol version: '%s'", reply)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Chmod(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().VaultPath().Return(dir+"/vault", nil).AnyTimes()
+	cfg.EXPECT().Eval("", "connection", "transport", gomock.Any()).Return("unix", nil).AnyTimes()
+	cfg.EXPECT().Eval("", gomock.Any(), gomock.Any(), gomock.Any()).Return("", errors.New("not set")).AnyTimes()
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+	xdg.EXPECT().DataHome().Return(dir, nil).AnyTimes()
+
+	// the same server can be started again in the same process
+	for i := 0; i < 2; i++ {
+		local, err := Start(cfg)
+		if err != nil {
+			t.Fatalf("start #%d: %s", i, err)
+		}
+		status := make(chan int)
+		go func() {
+			s, err := local.Wait()
+			if err != nil {
+				t.Error(err)
+			}
+			status <- s
+		}()
+		var stopped bool
+		err = local.Server().Stop(3, &stopped)
+		if err != nil {
+			t.Fatalf("stop #%d: %s", i, err)
+		}
+		if s := <-status; s != 3 {
+			t.Errorf("bad status #%d: %d", i, s)
+		}
+	}
+}
+
+// Hammer the server with concurrent operations (build.sh runs it with
+// -race)
+func TestConcurrentOperations(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	srv := newTestServer(t, cfg, time.Hour, 0)
+
+	const workers = 8
+	const count = 30
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				name := fmt.Sprintf("key%d-%d", i, j)
+				pass := fmt.Sprintf("pass%d-%d", i, j)
+				var reply string
+				err := srv.Set(server.SetArgs{Key: name, Pass: pass}, &reply)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if reply != pass {
+					t.Errorf("bad Set reply for %s: '%s'", name, reply)
+				}
+				var ok bool
+				err = srv.SetField(server.SetFieldArgs{Key: name, Field: Username, Value: "me"}, &ok)
+				if err != nil {
+					t.Error(err)
+				}
+				var list []string
+				err = srv.List("^key", &list)
+				if err != nil {
+					t.Error(err)
+				}
+				var info server.KeyInfo
+				err = srv.Info(server.InfoArgs{Key: name}, &info)
+				if err != nil {
+					t.Error(err)
+				} else if info.Username != "me" {
+					t.Errorf("bad Info reply for %s: %v", name, info)
+				}
+				var history []time.Time
+				err = srv.History(name, &history)
+				if err != nil {
+					t.Error(err)
+				}
+				err = srv.Get("foo", &reply)
+				if err != nil {
+					t.Error(err)
+				} else if reply != "bar" {
+					t.Errorf("bad Get reply: '%s'", reply)
+				}
+				if i == 0 && j%10 == 5 {
+					err = srv.Save(false, &ok)
+					if err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var list []string
+	err := srv.List(".*", &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != workers*count+1 {
+		t.Errorf("lost keys: %d instead of %d", len(list), workers*count+1)
+	}
+}
+
+// The same, through the RPC channel, with several clients
+func TestConcurrentClients(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Chmod(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := core.NewMockConfig(ctrl)
+	xdg := core.NewMockXdgContext(ctrl)
+	cfg.EXPECT().VaultPath().Return(dir+"/vault", nil).AnyTimes()
+	cfg.EXPECT().Eval("", "connection", "transport", gomock.Any()).Return("unix", nil).AnyTimes()
+	cfg.EXPECT().Eval("", gomock.Any(), gomock.Any(), gomock.Any()).Return("", errors.New("not set")).AnyTimes()
+	cfg.EXPECT().Xdg().Return(xdg, nil).AnyTimes()
+	xdg.EXPECT().RuntimeDir().Return(dir, nil).AnyTimes()
+	xdg.EXPECT().DataHome().Return(dir, nil).AnyTimes()
+
+	local, err := Start(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := make(chan int)
+	go func() {
+		s, err := local.Wait()
+		if err != nil {
+			t.Error(err)
+		}
+		status <- s
+	}()
+
+	start := func() error {
+		return errors.New("unexpected server start")
+	}
+
+	var ok bool
+	client, err := Proxy(cfg, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Open("secret", &ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 4
+	const count = 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, err := Proxy(cfg, start)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for j := 0; j < count; j++ {
+				name := fmt.Sprintf("key%d-%d", i, j)
+				var reply string
+				err := client.Set(server.SetArgs{Key: name, Pass: fmt.Sprintf("pass%d-%d", i, j)}, &reply)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var pass string
+				err = client.Get(name, &pass)
+				if err != nil {
+					t.Error(err)
+				} else if pass != reply {
+					t.Errorf("bad Get reply for %s: '%s' != '%s'", name, pass, reply)
+				}
+				var list []string
+				err = client.List(".*", &list)
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var list []string
+	err = client.List(".*", &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != workers*count {
+		t.Errorf("lost keys: %d instead of %d", len(list), workers*count)
+	}
+
+	err = client.Stop(0, &ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-status
+}
+
+func TestRestoreBackupLocks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := core.NewMockConfig(ctrl)
+	b := &backups{dir: dir + "/backups", keep: 5}
+	srv := &serverImpl{
+		vault:     newVault(dir+"/vault", b),
+		vaultPath: dir + "/vault",
+		backups:   b,
+		config:    cfg,
+		status:    make(chan int, 1),
+		running:   true,
+	}
+
+	var ok bool
+	err = srv.Open("secret", &ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	for _, pass := range []string{"bar", "baz"} {
+		err = srv.Set(server.SetArgs{Key: "foo", Pass: pass}, &reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = srv.Save(false, &ok)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var list []server.Backup
+	err = srv.Backups("", &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("bad backups: %v", list)
+	}
+	err = srv.RestoreBackup(list[0].Name, &ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = srv.Get("foo", &reply)
+	if !server.IsLocked(err) {
+		t.Fatalf("expected locked vault, got %v", err)
+	}
+	err = srv.Open("secret", &ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = srv.Get("foo", &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "bar" {
+		t.Errorf("bad restored password: '%s'", reply)
+	}
+}
+
+func TestLoadVault(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the vault to load
+	other := newVault(dir+"/other", nil)
+	err = other.Open("other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.SetPass("foo", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Save(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := core.NewMockConfig(ctrl)
+	b := &backups{dir: dir + "/backups", keep: 5}
+	srv := &serverImpl{
+		vault:     newVault(dir+"/vault", b),
+		vaultPath: dir + "/vault",
+		backups:   b,
+		config:    cfg,
+		status:    make(chan int, 1),
+		running:   true,
+	}
+
+	var ok bool
+	err = srv.Open("secret", &ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	err = srv.Set(server.SetArgs{Key: "foo", Pass: "first"}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = srv.Save(false, &ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = srv.Set(server.SetArgs{Key: "foo", Pass: "local"}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// refused: the local vault is unchanged, and still open
+	err = srv.Load(server.MergeArgs{Vault: dir + "/other", Master: "wrong"}, &ok)
+	if err == nil {
+		t.Error("expected error")
+	}
+	err = srv.Get("foo", &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "local" {
+		t.Errorf("bad password: '%s'", reply)
+	}
+
+	err = srv.Load(server.MergeArgs{Vault: dir + "/other", Master: "other"}, &ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = srv.Get("foo", &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "remote" {
+		t.Errorf("bad loaded password: '%s'", reply)
+	}
+
+	// the local changes were saved, then backed up (only once)
+	var list []server.Backup
+	err = srv.Backups("", &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("bad backups: %v", list)
+	}
+	v := newVault(dir+"/backups/"+list[0].Name, nil)
+	err = v.Open("secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := v.Item("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Password() != "local" {
+		t.Errorf("bad backed up password: '%s'", k.Password())
+	}
+}
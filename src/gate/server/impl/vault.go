@@ -20,12 +20,13 @@ package impl
 import (
 	"gate/core"
 	"gate/core/errors"
-	"gate/core/exec"
+	"gate/server"
 )
 
 import (
-	"fmt"
+	"bytes"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"regexp"
@@ -39,7 +40,8 @@ type In func() (io.ReadCloser, error)
 // Return a writer
 type Out func() (io.WriteCloser, error)
 
-// The vault interface.
+// The vault interface. A vault is not safe for concurrent use: the
+// server guards it with its own lock.
 type Vault interface {
 	Open(master string, config core.Config) error
 	IsOpen() bool
@@ -47,23 +49,34 @@ type Vault interface {
 	Item(name string) (Key, error)
 	List(filter string) ([]string, error)
 	Merge(other Vault) error
+	Diff(other Vault) (server.MergeDiff, error)
+	Changes(previous In, config core.Config) (server.MergeDiff, error)
+	Conflicts(filter string) ([]string, error)
+	Conflict(name string) (Key, error)
+	Resolve(name string, choice string) error
 	Save(force bool, config core.Config) error
 	SetRandom(name string, recipe string) error
 	SetPass(name string, pass string) error
+	SetField(name string, field string, value string) error
+	Rollback(name string, version int) error
 	Unset(name string) error
 	SetMaster(master string) error
 }
 
 type vault struct {
-	data	map[string]Key
-	dirty	bool
-	in	In
-	out	Out
-	open	bool
-	master	string
+	data    map[string]Key
+	dirty   bool
+	in      In
+	out     Out
+	open    bool
+	master  string
+	cipher  Cipher
+	records string
 	recipes map[string]Generator
-	decode	func(*vault, io.ReadCloser, chan error)
-	newkey	func(string, string) Key
+	// the other keys of the last merge conflicts, not yet resolved
+	conflicts map[string]Key
+	decode    func(*vault, io.ReadCloser, chan error)
+	newkey    func(string, string) Key
 }
 
 var _ Vault = &vault{}
@@ -72,15 +85,20 @@ func finalize(v *vault) {
 	v.Close(nil)
 }
 
-// Create a new vault.
+// Create a new vault. Its actual format (cipher and records) is
+// read from the vault header when it is opened; new vaults use the
+// default format.
 func NewVault(in In, out Out) (result Vault) {
+	format := record_formats[default_records]
 	v := &vault{
-		data:	 make(map[string]Key),
-		in:	 in,
-		out:	 out,
-		recipes: make(map[string]Generator, 32),
-		decode:	 bf_decode,
-		newkey:	 bf_newkey,
+		data:      make(map[string]Key),
+		in:        in,
+		out:       out,
+		records:   default_records,
+		recipes:   make(map[string]Generator, 32),
+		conflicts: make(map[string]Key),
+		decode:    format.decode,
+		newkey:    format.newkey,
 	}
 	runtime.SetFinalizer(v, finalize)
 	result = v
@@ -90,47 +108,122 @@ func NewVault(in In, out Out) (result Vault) {
 func (self *vault) Open(master string, config core.Config) (err error) {
 	instream, err := self.in()
 	if err != nil {
+		if os.IsNotExist(err) {
+			return self.create(master)
+		}
 		return errors.Decorated(err)
 	}
 	defer instream.Close()
 
-	cipher, err := config.Eval("", "vault", "openssl.cipher", os.Getenv)
+	header, cipher, problems, err := self.read(instream, master, config)
 	if err != nil {
 		return
 	}
+	if len(problems) > 0 {
+		// a corrupt vault is never used, not even partly
+		self.data = make(map[string]Key)
+		return errors.Newf("Invalid vault: %s (%d problem(s), see the verify command)", errorMessage(problems[0]), len(problems))
+	}
 
-	barrier := make(chan error)
-
-	prepare := func(cmd *exec.Cmd) (err error) {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("VAULT_MASTER=%s", master))
-		cmd.Stdin = instream
-
-		out, err := cmd.StdoutPipe()
+	if header.version == vault_version && cipher.Name() == default_cipher {
+		self.cipher = cipher
+	} else {
+		// older vault: will be written using the current format
+		self.cipher, err = NewCipher(default_cipher, nil)
 		if err != nil {
-			return errors.Decorated(err)
+			return
 		}
+		self.dirty = true
+	}
 
-		go self.decode(self, out, barrier)
+	self.master = master
+	self.open = true
+	return
+}
 
+// Read and decrypt the vault file, and decode its records. The
+// problems found in the records are returned: the data only holds the
+// valid records.
+func (self *vault) read(in io.Reader, master string, config core.Config) (header *vault_header, cipher Cipher, problems []error, err error) {
+	header, data, err := readVaultFile(in, config)
+	if err != nil {
 		return
 	}
 
-	run := func(cmd *exec.Cmd) (err error) {
-		e := <-barrier
-		if e != io.EOF {
-			err = errors.Decorated(e)
-		}
+	cipher, err = NewCipher(header.cipher, header.parameters)
+	if err != nil {
+		return
+	}
 
+	format, err := recordFormat(header.records)
+	if err != nil {
 		return
 	}
 
-	err = exec.Command(prepare, run, "openssl", cipher, "-d", "-a", "-pass", "env:VAULT_MASTER")
+	plain, err := cipher.Decrypt(data, master)
 	if err != nil {
 		return
 	}
 
+	self.records = header.records
+	self.decode = format.decode
+	self.newkey = format.newkey
+
+	// the decoder sends each problem found, then io.EOF
+	barrier := make(chan error)
+	go self.decode(self, ioutil.NopCloser(bytes.NewReader(plain)), barrier)
+	for e := <-barrier; e != io.EOF; e = <-barrier {
+		problems = append(problems, e)
+	}
+	return
+}
+
+// Check a vault file without opening it: decrypt it, and decode all
+// its records. The problems are reported, not returned as an error.
+func verifyVault(in In, master string, config core.Config) (result server.VerifyReport, err error) {
+	instream, err := in()
+	if err != nil {
+		return result, errors.Decorated(err)
+	}
+	defer instream.Close()
+
+	v := NewVault(in, nil).(*vault)
+	defer v.Close(nil)
+
+	header, cipher, problems, e := v.read(instream, master, config)
+	if header != nil {
+		result.Records = header.records
+	}
+	if cipher != nil {
+		result.Cipher = cipher.Name()
+	}
+	result.Problems = []string{}
+	if e != nil {
+		result.Problems = append(result.Problems, errorMessage(e))
+		return
+	}
+	for _, problem := range problems {
+		result.Problems = append(result.Problems, errorMessage(problem))
+	}
+	for _, k := range v.data {
+		if k.IsDeleted() {
+			result.Deleted++
+		} else {
+			result.Keys++
+		}
+	}
+	return
+}
+
+// Open a brand new vault (its file does not exist yet)
+func (self *vault) create(master string) (err error) {
+	self.cipher, err = NewCipher(default_cipher, nil)
+	if err != nil {
+		return
+	}
 	self.master = master
 	self.open = true
+	self.dirty = true
 	return
 }
 
@@ -140,6 +233,13 @@ func (self *vault) IsOpen() bool {
 
 func (self *vault) Close(config core.Config) (err error) {
 	if config != nil {
+		// an interrupted merge: keep both passwords rather than lose one
+		for name := range self.conflicts {
+			err = self.Resolve(name, server.ResolveBoth)
+			if err != nil {
+				return
+			}
+		}
 		err = self.Save(false, config)
 		if err != nil {
 			return
@@ -147,6 +247,7 @@ func (self *vault) Close(config core.Config) (err error) {
 	}
 
 	self.data = make(map[string]Key)
+	self.conflicts = make(map[string]Key)
 	self.open = false
 	self.master = ""
 
@@ -188,74 +289,244 @@ func (self *vault) List(filter string) (result []string, err error) {
 
 func (self *vault) Merge(o Vault) (err error) {
 	other := o.(*vault)
+	self.conflicts = make(map[string]Key)
 	for keyname, key := range self.data {
 		other_key, ok := other.data[keyname]
 		if ok {
-			key.Merge(other_key)
+			if key.Conflicts(other_key) {
+				// kept aside, until resolved
+				self.conflicts[keyname] = self.adopt(other_key, other.records)
+			} else {
+				key.Merge(other_key)
+			}
 		}
 	}
 	for keyname, key := range other.data {
 		_, ok := self.data[keyname]
 		if !ok {
-			self.data[keyname] = key
+			self.data[keyname] = self.adopt(key, other.records)
 		}
 	}
 	self.dirty = true
 	return
 }
 
-func (self *vault) save(config core.Config) (err error) {
-	outstream, err := self.out()
-	if err != nil {
-		return errors.Decorated(err)
+// Tell what Merge would change, without changing anything
+func (self *vault) Diff(o Vault) (result server.MergeDiff, err error) {
+	other := o.(*vault)
+	names := make([]string, 0, len(other.data))
+	for name := range other.data {
+		names = append(names, name)
 	}
-	defer outstream.Close()
+	sort.Strings(names)
 
-	cipher, err := config.Eval("", "vault", "openssl.cipher", os.Getenv)
+	for _, name := range names {
+		other_key := other.data[name]
+		key, ok := self.data[name]
+		if !ok {
+			if !other_key.IsDeleted() {
+				result.Added = append(result.Added, name)
+			}
+			continue
+		}
+		if key.Conflicts(other_key) {
+			result.Conflicting = append(result.Conflicting, name)
+			continue
+		}
+		local := key.keyData()
+		merged := *local
+		merged.Merge(other_key)
+		switch {
+		case !local.IsDeleted() && merged.IsDeleted():
+			result.Deleted = append(result.Deleted, name)
+		case local.IsDeleted() && !merged.IsDeleted():
+			result.Added = append(result.Added, name)
+		case merged.IsDeleted():
+			// still deleted
+		case merged.pass != local.pass || !sameFields(merged.fields, local.fields):
+			result.Updated = append(result.Updated, name)
+		}
+	}
+	return
+}
+
+// Tell what changed since a previous version of this vault (read with
+// the same master): the keys added, updated or deleted since then. The
+// keys are compared, not their dates, which come from other clocks.
+func (self *vault) Changes(previous In, config core.Config) (result server.MergeDiff, err error) {
+	other := NewVault(previous, nil).(*vault)
+	err = other.Open(self.master, config)
 	if err != nil {
-		return err
+		return
 	}
+	// closed without saving: nothing must change
+	defer other.Close(nil)
 
-	pipe := make(chan io.WriteCloser, 1)
+	names := make([]string, 0, len(self.data)+len(other.data))
+	for name := range self.data {
+		names = append(names, name)
+	}
+	for name := range other.data {
+		if _, ok := self.data[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key, ok := self.data[name]
+		live := ok && !key.IsDeleted()
+		old_key, ok := other.data[name]
+		was_live := ok && !old_key.IsDeleted()
+		switch {
+		case live && !was_live:
+			result.Added = append(result.Added, name)
+		case !live && was_live:
+			result.Deleted = append(result.Deleted, name)
+		case !live:
+			// still deleted, or never there
+		case key.Password() != old_key.Password() || !sameFields(key.Fields(), old_key.Fields()):
+			result.Updated = append(result.Updated, name)
+		}
+	}
+	return
+}
 
-	prepare := func(cmd *exec.Cmd) (err error) {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("VAULT_MASTER=%s", self.master))
-		cmd.Stdout = outstream
-		p, err := cmd.StdinPipe()
-		if err != nil {
-			return errors.Decorated(err)
+func sameFields(fields1, fields2 map[string]string) bool {
+	if len(fields1) != len(fields2) {
+		return false
+	}
+	for name, value := range fields1 {
+		if value2, ok := fields2[name]; !ok || value2 != value {
+			return false
 		}
-		pipe <- p
+	}
+	return true
+}
+
+func (self *vault) Conflicts(filter string) (result []string, err error) {
+	re_filter, err := regexp.Compile(filter)
+	if err != nil {
+		err = errors.Decorated(err)
 		return
 	}
 
-	run := func(cmd *exec.Cmd) (err error) {
-		p := <-pipe
-		for _, k := range self.data {
-			code := k.Encoded()
-			n, err := p.Write([]byte(code))
-			if err != nil {
-				return errors.Decorated(err)
-			}
-			if n < len(code) {
-				return errors.Newf("Incomplete write")
-			}
+	result = make([]string, 0, len(self.conflicts))
+	for name := range self.conflicts {
+		if re_filter.MatchString(name) {
+			result = append(result, name)
 		}
-		err = p.Close()
+	}
+	sort.Strings(result)
+	return
+}
+
+func (self *vault) Conflict(name string) (result Key, err error) {
+	result, ok := self.conflicts[name]
+	if !ok {
+		err = errors.Newf("No merge conflict for key: %s", name)
+	}
+	return
+}
+
+func (self *vault) Resolve(name string, choice string) (err error) {
+	other, err := self.Conflict(name)
+	if err != nil {
+		return
+	}
+	key := self.data[name]
+	switch choice {
+	case server.ResolveLocal:
+		key.Resolve(other, false)
+	case server.ResolveRemote:
+		key.Resolve(other, true)
+	case server.ResolveBoth:
+		// the local version wins, the remote one is kept aside
+		key.Resolve(other, false)
+		err = self.SetPass(name+".conflict", other.Password())
 		if err != nil {
-			return errors.Decorated(err)
+			return
 		}
+	default:
+		return errors.Newf("Invalid choice: %s", choice)
+	}
+	delete(self.conflicts, name)
+	self.dirty = true
+	return
+}
+
+// Return the given key, converted to this vault's records format if needed
+func (self *vault) adopt(key Key, records string) (result Key) {
+	if records == self.records {
+		return key
+	}
+	result = self.newkey(key.Name(), "")
+	*result.keyData() = *key.keyData()
+	return
+}
+
+func (self *vault) save() (err error) {
+	buffer := &bytes.Buffer{}
+	for _, k := range self.data {
+		buffer.WriteString(k.Encoded())
+	}
+
+	data, err := self.cipher.Encrypt(buffer.Bytes(), self.master)
+	if err != nil {
 		return
 	}
 
-	err = exec.Command(prepare, run, "openssl", cipher, "-a", "-pass", "env:VAULT_MASTER")
+	content := &bytes.Buffer{}
+	err = writeVaultFile(content, newVaultHeader(self.cipher, self.records), data)
+	if err != nil {
+		return
+	}
+
+	err = self.check(content.Bytes(), buffer.Bytes())
+	if err != nil {
+		return
+	}
+
+	outstream, err := self.out()
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	_, err = outstream.Write(content.Bytes())
+	e := outstream.Close()
+	if err != nil {
+		return errors.Decorated(err)
+	}
+	return e
+}
 
+// Check that the vault file content decrypts back to the plain data,
+// before writing it
+func (self *vault) check(content []byte, plain []byte) (err error) {
+	header, data, err := readVaultFile(bytes.NewReader(content), nil)
+	if err != nil {
+		return
+	}
+	cipher, err := NewCipher(header.cipher, header.parameters)
+	if err != nil {
+		return
+	}
+	decrypted, err := cipher.Decrypt(data, self.master)
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(decrypted, plain) {
+		return errors.Newf("Vault check failed: cannot save")
+	}
 	return
 }
 
 func (self *vault) Save(force bool, config core.Config) (err error) {
+	if len(self.conflicts) > 0 {
+		// the local passwords would silently win
+		return errors.Newf("Unresolved merge conflicts (%d): merge again", len(self.conflicts))
+	}
 	if self.dirty || force {
-		err = self.save(config)
+		err = self.save()
 		if err != nil {
 			return
 		}
@@ -285,7 +556,13 @@ func (self *vault) SetRandom(name string, recipe string) (err error) {
 }
 
 func (self *vault) Unset(name string) (err error) {
-	delete(self.data, name)
+	k, ok := self.data[name]
+	if !ok || k.IsDeleted() {
+		return errors.Newf("Unknown key: %s", name)
+	}
+	// the key is kept as a tombstone, to let merge propagate the deletion
+	k.Delete()
+	self.dirty = true
 	return
 }
 
@@ -301,6 +578,32 @@ func (self *vault) SetPass(name string, pass string) (err error) {
 	return
 }
 
+func (self *vault) SetField(name string, field string, value string) (err error) {
+	k, ok := self.data[name]
+	if !ok || k.IsDeleted() {
+		return errors.Newf("Unknown key: %s", name)
+	}
+	err = k.SetField(field, value)
+	if err != nil {
+		return
+	}
+	self.dirty = true
+	return
+}
+
+func (self *vault) Rollback(name string, version int) (err error) {
+	k, ok := self.data[name]
+	if !ok || k.IsDeleted() {
+		return errors.Newf("Unknown key: %s", name)
+	}
+	err = k.Rollback(version)
+	if err != nil {
+		return
+	}
+	self.dirty = true
+	return
+}
+
 func (self *vault) SetMaster(master string) (err error) {
 	if master == "" {
 		err = errors.Newf("empty master not allowed")
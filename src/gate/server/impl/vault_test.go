@@ -0,0 +1,733 @@
+// This file is part of Gate.
+// Copyright (C) 2012-2015 Cyril Adrian <cyril.adrian@gmail.com>
+//
+// Gate is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, version 3 of the License.
+//
+// Gate is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.	 See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Gate.  If not, see <http://www.gnu.org/licenses/>.
+
+package impl
+
+import (
+	"gate/core"
+	"gate/server"
+)
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestVault(t *testing.T, keys map[string]string) Vault {
+	v, _ := memVault("")
+	err := v.(*vault).create("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pass := range keys {
+		err = v.SetPass(name, pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return v
+}
+
+func checkList(t *testing.T, v Vault, expected ...string) {
+	list, err := v.List(".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expected) == 0 {
+		expected = []string{}
+	}
+	if !reflect.DeepEqual(list, expected) {
+		t.Errorf("bad list: %v != %v", list, expected)
+	}
+}
+
+func TestUnsetKeepsTombstone(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+
+	v, file := memVault("")
+	err := v.(*vault).create("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.SetPass("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.Unset("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, v)
+
+	err = v.Unset("foo")
+	if err == nil {
+		t.Errorf("deleted a deleted key")
+	}
+
+	err = v.Save(false, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2, _ := memVault(file.String())
+	err = v2.Open("secret", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := v2.Item("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k.IsDeleted() || k.Password() != "" {
+		t.Errorf("tombstone not kept")
+	}
+}
+
+func TestMergePropagatesDeletion(t *testing.T) {
+	local := newTestVault(t, map[string]string{"foo": "bar", "baz": "qux"})
+	remote := newTestVault(t, map[string]string{"foo": "bar", "baz": "qux"})
+
+	err := remote.Unset("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = local.Unset("baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = local.Merge(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, local)
+
+	err = remote.Merge(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, remote)
+}
+
+func TestMergeReAddAfterDeletion(t *testing.T) {
+	local := newTestVault(t, map[string]string{"foo": "bar"})
+	remote := newTestVault(t, map[string]string{"foo": "bar"})
+
+	err := remote.Unset("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = local.Unset("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = local.SetPass("foo", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = local.Merge(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, local, "foo")
+
+	err = remote.Merge(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, remote, "foo")
+	k, err := remote.Item("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Password() != "new" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+}
+
+func TestFieldsRoundTrip(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+
+	v, file := memVault("")
+	err := v.(*vault).create("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.SetPass("foo", "bar:baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.SetField("foo", Username, "john")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.SetField("foo", Notes, "line 1\nline 2: \"quoted\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.SetField("foo", "password", "oops")
+	if err == nil {
+		t.Errorf("reserved field accepted")
+	}
+	err = v.SetField("bar", Username, "john")
+	if err == nil {
+		t.Errorf("field set on unknown key")
+	}
+	err = v.Save(false, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2, _ := memVault(file.String())
+	err = v2.Open("secret", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := v2.Item("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Password() != "bar:baz" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+	expected := map[string]string{Username: "john", Notes: "line 1\nline 2: \"quoted\""}
+	if !reflect.DeepEqual(k.Fields(), expected) {
+		t.Errorf("bad fields: %v", k.Fields())
+	}
+	if k.Created().IsZero() || k.Modified().IsZero() {
+		t.Errorf("missing dates")
+	}
+}
+
+func TestMergeFields(t *testing.T) {
+	local := newTestVault(t, map[string]string{"foo": "bar"})
+	remote := newTestVault(t, map[string]string{"foo": "bar"})
+
+	err := remote.SetField("foo", Url, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.(*vault).data["foo"].keyData().modified = time.Now().Add(time.Hour)
+
+	err = local.Merge(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := local.Item("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Field(Url) != "https://example.com" {
+		t.Errorf("field not merged: %v", k.Fields())
+	}
+}
+
+func TestHistoryRollback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+
+	v, file := memVault("")
+	err := v.(*vault).create("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pass := range []string{"one", "two", "three"} {
+		err = v.SetPass("foo", pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = v.Save(false, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2, _ := memVault(file.String())
+	err = v2.Open("secret", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := v2.Item("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.History()) != 2 {
+		t.Fatalf("bad history: %v", k.History())
+	}
+
+	err = v2.Rollback("foo", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Password() != "one" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+	if len(k.History()) != 2 || k.keyData().history[0].pass != "three" {
+		t.Errorf("bad history after rollback: %v", k.keyData().history)
+	}
+
+	err = v2.Rollback("foo", 3)
+	if err == nil {
+		t.Errorf("rolled back to an unknown version")
+	}
+}
+
+func TestHistoryBounded(t *testing.T) {
+	v := newTestVault(t, map[string]string{})
+	for i := 0; i < 2*history_size; i++ {
+		err := v.SetPass("foo", fmt.Sprintf("pass%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	k, _ := v.Item("foo")
+	if len(k.History()) != history_size {
+		t.Errorf("history not bounded: %d", len(k.History()))
+	}
+}
+
+func TestMergeHistory(t *testing.T) {
+	local := newTestVault(t, map[string]string{"foo": "old"})
+	remote := newTestVault(t, map[string]string{"foo": "one"})
+
+	err := local.SetPass("foo", "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pass := range []string{"two", "three", "four"} {
+		err = remote.SetPass("foo", pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = local.Merge(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _ := local.Item("foo")
+	if k.Password() != "four" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+	known := map[string]bool{}
+	for _, version := range k.keyData().history {
+		known[version.pass] = true
+	}
+	if !reflect.DeepEqual(known, map[string]bool{"old": true, "one": true, "two": true, "three": true}) {
+		t.Errorf("bad merged history: %v", k.keyData().history)
+	}
+
+	err = local.Unset("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.History()) != 0 {
+		t.Errorf("history kept in tombstone")
+	}
+}
+
+func TestMergeConflict(t *testing.T) {
+	local := newTestVault(t, map[string]string{"foo": "one", "bar": "one", "baz": "one"})
+	remote := newTestVault(t, map[string]string{"foo": "one", "bar": "one", "baz": "one"})
+
+	for name, pass := range map[string]string{"foo": "local", "bar": "local", "baz": "local"} {
+		err := local.SetPass(name, pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, pass := range map[string]string{"foo": "remote", "bar": "remote", "baz": "remote"} {
+		err := remote.SetPass(name, pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := local.Merge(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts, err := local.Conflicts(".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conflicts, []string{"bar", "baz", "foo"}) {
+		t.Fatalf("bad conflicts: %v", conflicts)
+	}
+
+	for name, choice := range map[string]string{"foo": server.ResolveLocal, "bar": server.ResolveRemote, "baz": server.ResolveBoth} {
+		err = local.Resolve(name, choice)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	conflicts, _ = local.Conflicts(".*")
+	if len(conflicts) != 0 {
+		t.Errorf("conflicts not resolved: %v", conflicts)
+	}
+	for name, pass := range map[string]string{"foo": "local", "bar": "remote", "baz": "local", "baz.conflict": "remote"} {
+		k, err := local.Item(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.Password() != pass {
+			t.Errorf("bad password for %s: '%s'", name, k.Password())
+		}
+	}
+
+	// the resolved vault supersedes the remote one
+	err = remote.Merge(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts, _ = remote.Conflicts(".*")
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+	k, _ := remote.Item("foo")
+	if k.Password() != "local" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+}
+
+func TestMergeConflictNotResolved(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := core.NewMockConfig(ctrl)
+	cfg.EXPECT().Eval(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return("", nil).AnyTimes()
+
+	local, file := memVault("")
+	err := local.(*vault).create("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := newTestVault(t, map[string]string{"foo": "remote"})
+	err = local.SetPass("foo", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = local.Merge(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts, _ := local.Conflicts(".*")
+	if len(conflicts) != 1 {
+		t.Fatalf("expected a conflict: %v", conflicts)
+	}
+
+	// the local password would silently win
+	err = local.Save(true, cfg)
+	if err == nil {
+		t.Error("expected error")
+	}
+
+	// locked anyway: both passwords are kept
+	err = local.Close(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, _ := memVault(file.String())
+	err = reopened.Open("secret", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pass := range map[string]string{"foo": "local", "foo.conflict": "remote"} {
+		k, err := reopened.Item(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.Password() != pass {
+			t.Errorf("bad password for %s: '%s'", name, k.Password())
+		}
+	}
+}
+
+func TestMergeNoConflict(t *testing.T) {
+	local := newTestVault(t, map[string]string{"foo": "one"})
+	remote := newTestVault(t, map[string]string{"foo": "one"})
+
+	err := remote.SetPass("foo", "two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = local.Merge(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = local.SetPass("foo", "three")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Merge(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts, _ := remote.Conflicts(".*")
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+	k, _ := remote.Item("foo")
+	if k.Password() != "three" {
+		t.Errorf("bad password: '%s'", k.Password())
+	}
+}
+
+func TestDiff(t *testing.T) {
+	local := newTestVault(t, map[string]string{"same": "one", "updated": "one", "deleted": "one", "conflict": "one", "gone": "one"})
+	remote := newTestVault(t, map[string]string{"same": "one", "updated": "one", "deleted": "one", "conflict": "one", "added": "one"})
+
+	err := remote.SetPass("updated", "two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Unset("deleted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.SetPass("conflict", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = local.SetPass("conflict", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = local.(*vault).Save(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := local.Diff(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := server.MergeDiff{
+		Added:       []string{"added"},
+		Updated:     []string{"updated"},
+		Deleted:     []string{"deleted"},
+		Conflicting: []string{"conflict"},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("bad diff: %v", diff)
+	}
+
+	// nothing changed
+	checkList(t, local, "conflict", "deleted", "gone", "same", "updated")
+	k, _ := local.Item("updated")
+	if k.Password() != "one" {
+		t.Errorf("vault changed by diff")
+	}
+	if local.(*vault).dirty {
+		t.Errorf("vault dirty after diff")
+	}
+}
+
+func TestChanges(t *testing.T) {
+	previous, file := memVault("")
+	err := previous.(*vault).create("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"same", "updated", "field", "deleted", "tombstone"} {
+		err = previous.SetPass(name, "one")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = previous.Unset("tombstone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = previous.Save(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := file.String()
+
+	current, _ := memVault(saved)
+	err = current.Open("secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = current.SetPass("updated", "two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = current.SetField("field", "url", "http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = current.Unset("deleted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = current.SetPass("added", "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// merged keys are changes too, whatever their dates
+	err = current.Merge(newTestVault(t, map[string]string{"merged": "one"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(saved)), nil
+	}
+	changes, err := current.Changes(in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := server.MergeDiff{
+		Added:   []string{"added", "merged"},
+		Updated: []string{"field", "updated"},
+		Deleted: []string{"deleted"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("bad changes: %v", changes)
+	}
+
+	// another master: the previous version cannot be read
+	other := newTestVault(t, map[string]string{"foo": "one"})
+	err = other.(*vault).SetMaster("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.Changes(in, nil)
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+// A vault file holding the given (scrypt) records
+func encryptedVault(t *testing.T, records string, master string) string {
+	cipher, err := NewCipher(default_cipher, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := cipher.Encrypt([]byte(records), master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &bytes.Buffer{}
+	err = writeVaultFile(file, newVaultHeader(cipher, "scrypt"), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.String()
+}
+
+const corrupt_records = `foo:c2FsdA==:1:0:bar
+	created:1420070400
+	orphan:1
+garbage
+	created:1420070400
+foo:c2FsdA==:2:0:baz
+qux:c2FsdA==:1:5:old
+`
+
+func TestOpenCorruptVault(t *testing.T) {
+	v, _ := memVault(encryptedVault(t, corrupt_records, "secret"))
+	err := v.Open("secret", nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if v.IsOpen() {
+		t.Error("corrupt vault is open")
+	}
+}
+
+func TestVerifyVault(t *testing.T) {
+	_, file := memVault(encryptedVault(t, corrupt_records, "secret"))
+	in := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(file.Bytes())), nil
+	}
+
+	report, err := verifyVault(in, "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"line 3: invalid metadata for key foo",
+		"line 4: malformed record",
+		"line 5: metadata without key",
+		"line 6: duplicate key foo",
+		"line 7: impossible counters for key qux: added 1 times, deleted 5 times",
+	}
+	if !reflect.DeepEqual(report.Problems, expected) {
+		t.Errorf("bad problems:\n%s", strings.Join(report.Problems, "\n"))
+	}
+	if report.Keys != 1 || report.Cipher != "aes-256-gcm" || report.Records != "scrypt" {
+		t.Errorf("bad report: %v", report)
+	}
+
+	report, err = verifyVault(in, "wrong", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || !strings.HasPrefix(report.Problems[0], "Could not decrypt vault") {
+		t.Errorf("bad problems: %v", report.Problems)
+	}
+}
+
+func TestVerifyValidVault(t *testing.T) {
+	v, file := memVault("")
+	err := v.(*vault).create("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"foo", "bar", "baz"} {
+		err = v.SetPass(name, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = v.Unset("baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.Save(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(file.Bytes())), nil
+	}
+	report, err := verifyVault(in, "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 || report.Keys != 2 || report.Deleted != 1 {
+		t.Errorf("bad report: %v", report)
+	}
+}
@@ -16,7 +16,17 @@
 // Head package for the server definition
 package server
 
-// Arguments to the "merge" operation.
+import (
+	"gate/core/errors"
+)
+
+import (
+	"strings"
+	"time"
+)
+
+// Arguments to the "merge", "diff" and "load" operations: the other
+// vault file and its master.
 type MergeArgs struct {
 	Vault  string
 	Master string
@@ -29,6 +39,122 @@ type SetArgs struct {
 	Recipe string
 }
 
+// Arguments to the "setfield" operation. An empty value removes the
+// field.
+type SetFieldArgs struct {
+	Key   string
+	Field string
+	Value string
+}
+
+// Arguments to the "info" operation.
+type InfoArgs struct {
+	Key          string
+	WithPassword bool
+}
+
+// The key information returned by the "info" operation. The password
+// is only provided if explicitly asked for.
+type KeyInfo struct {
+	Name     string
+	Username string
+	Url      string
+	Notes    string
+	Fields   map[string]string
+	Created  time.Time
+	Modified time.Time
+	Password string
+}
+
+// Arguments to the "rollback" operation. The version is the index in
+// the key history: 1 is the most recent previous password.
+type RollbackArgs struct {
+	Key     string
+	Version int
+}
+
+// The result of the "diff" operation: what a merge would change in
+// the local vault. Also the result of the "changes" operation: what
+// changed in the server vault since a previous version of it (without
+// conflicts).
+type MergeDiff struct {
+	Added       []string
+	Updated     []string
+	Deleted     []string
+	Conflicting []string
+}
+
+// The ways to resolve a merge conflict: keep the local password, the
+// remote one, or both (the remote one being kept as "<key>.conflict")
+const (
+	ResolveLocal  = "local"
+	ResolveRemote = "remote"
+	ResolveBoth   = "both"
+)
+
+// A merge conflict: the password was changed both in the local vault
+// and in the merged one.
+type Conflict struct {
+	Key            string
+	LocalModified  time.Time
+	RemoteModified time.Time
+}
+
+// Arguments to the "resolve" operation.
+type ResolveArgs struct {
+	Key    string
+	Choice string
+}
+
+// A backup of the vault file, made before it was replaced.
+type Backup struct {
+	Name string
+	Date time.Time
+	Size int64
+}
+
+// Arguments to the "verify" operation: the vault file (the server
+// vault if empty) and its master.
+type VerifyArgs struct {
+	Vault  string
+	Master string
+}
+
+// The result of the "verify" operation. The vault is valid if there
+// are no problems.
+type VerifyReport struct {
+	Cipher   string
+	Records  string
+	Keys     int
+	Deleted  int
+	Problems []string
+}
+
+// The function used by the proxy to start the server when it cannot
+// connect to it.
+type ProxyStartFunc func() error
+
+// The version of the client-server protocol, returned by Ping when
+// asked with ProtocolQuery. Change it whenever an operation or its
+// arguments change.
+const ProtocolVersion = "6"
+
+// The Ping info that asks the protocol version
+const ProtocolQuery = "protocol?"
+
+// The error message returned by the operations when the vault was
+// locked by the server (after being idle for too long); the master is
+// needed to open the vault again.
+const LockedMessage = "Vault is locked"
+
+// True if the error tells that the vault was locked
+func IsLocked(err error) bool {
+	if e, ok := err.(errors.StackError); ok {
+		err = e.Nested
+	}
+	return err != nil && strings.HasPrefix(err.Error(), LockedMessage)
+}
+
 // The server interface implemented both by the actual (server-side)
 // object and the proxy.
 type Server interface {
@@ -39,8 +165,21 @@ type Server interface {
 	Unset(key string, reply *bool) error
 	List(filter string, reply *[]string) error
 	Merge(args MergeArgs, reply *bool) error
+	Diff(args MergeArgs, reply *MergeDiff) error
 	Save(force bool, reply *bool) error
 	Stop(status int, reply *bool) error
+	Lock(reason string, reply *bool) error
 	Ping(info string, reply *string) error
 	SetMaster(master string, reply *bool) error
+	Info(args InfoArgs, reply *KeyInfo) error
+	SetField(args SetFieldArgs, reply *bool) error
+	History(key string, reply *[]time.Time) error
+	Rollback(args RollbackArgs, reply *string) error
+	Conflicts(filter string, reply *[]Conflict) error
+	Resolve(args ResolveArgs, reply *bool) error
+	Backups(filter string, reply *[]Backup) error
+	RestoreBackup(name string, reply *bool) error
+	Verify(args VerifyArgs, reply *VerifyReport) error
+	Load(args MergeArgs, reply *bool) error
+	Changes(vault string, reply *MergeDiff) error
 }